@@ -0,0 +1,72 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestLock(t *testing.T) {
+	is := is.New(t)
+	root := t.TempDir()
+
+	l, err := Acquire(root)
+	is.NoErr(err)
+	is.Equal(Holder(root), os.Getpid())
+
+	_, err = Acquire(root)
+	is.True(errors.Is(err, ErrLocked)) // held
+	is.True(strings.Contains(err.Error(), fmt.Sprint(os.Getpid())))
+
+	is.NoErr(l.Release())
+	l, err = Acquire(root)
+	is.NoErr(err) // released
+	is.NoErr(l.Release())
+}
+
+func TestSocket(t *testing.T) {
+	is := is.New(t)
+	path := filepath.Join(t.TempDir(), SocketFile)
+	// A stale socket is replaced
+	is.NoErr(os.WriteFile(path, nil, 0600))
+
+	s, err := Listen(path, func(ctx context.Context, req Request, w io.Writer) error {
+		fmt.Fprintf(w, "%s/%s %s\n", req.User, req.Collection, strings.Join(req.Args, " "))
+		if req.Args[0] == "fail" {
+			return errors.New("it\nfailed")
+		}
+		return nil
+	})
+	is.NoErr(err)
+	fi, err := os.Stat(path)
+	is.NoErr(err)
+	is.Equal(fi.Mode().Perm(), os.FileMode(0600))
+	// Files created afterwards aren't restricted
+	umask := syscall.Umask(0022)
+	syscall.Umask(umask)
+	is.True(umask != 0077)
+
+	var out bytes.Buffer
+	is.NoErr(Run(context.Background(), path, Request{User: "alice", Collection: "work", Args: []string{"tag", "x"}}, &out))
+	is.Equal(out.String(), "alice/work tag x\n")
+
+	out.Reset()
+	err = Run(context.Background(), path, Request{Args: []string{"fail"}}, &out)
+	is.Equal(err.Error(), "it failed")
+	is.Equal(out.String(), "/ fail\n") // output before the error
+
+	is.NoErr(s.Shutdown(context.Background()))
+	_, err = os.Stat(path)
+	is.True(os.IsNotExist(err)) // socket removed
+	err = Run(context.Background(), path, Request{Args: []string{"x"}}, &out)
+	is.True(errors.Is(err, ErrUnreachable))
+}
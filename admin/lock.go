@@ -0,0 +1,76 @@
+// Package admin keeps a single process at a time writing to the data root,
+// and lets command line runs reach the server holding it over a local socket.
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	// LockFile in the data root is locked by the process writing to it.
+	LockFile = "xbellum.lock"
+	// SocketFile in the data root is where the server takes commands.
+	SocketFile = "admin.sock"
+)
+
+// ErrLocked tells another process holds the data root.
+var ErrLocked = errors.New("data directory in use")
+
+// Lock is held on a data root until released or the process exits.
+type Lock struct {
+	f *os.File
+}
+
+// Acquire locks root, failing with ErrLocked when another process holds it.
+// The pid of the holder is written in the lock file.
+func Acquire(root string) (*Lock, error) {
+	if err := os.MkdirAll(root, 0777); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(root, LockFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			if pid := Holder(root); pid != 0 {
+				return nil, fmt.Errorf("%w by pid %d", ErrLocked, pid)
+			}
+			return nil, ErrLocked
+		}
+		return nil, err
+	}
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &Lock{f: f}, nil
+}
+
+// Release unlocks the data root.
+func (l *Lock) Release() error {
+	// The pid is left behind, only the lock tells
+	return l.f.Close()
+}
+
+// Holder returns the pid last written in the lock file of root, 0 if unknown.
+func Holder(root string) int {
+	b, err := ioutil.ReadFile(filepath.Join(root, LockFile))
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(b)))
+	return pid
+}
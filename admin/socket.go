@@ -0,0 +1,132 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+
+	"go.uber.org/zap"
+)
+
+// Request is a command line run forwarded to the server.
+type Request struct {
+	// User and Collection are the -user and -collection flags.
+	User       string   `json:"user"`
+	Collection string   `json:"collection"`
+	Args       []string `json:"args"`
+}
+
+// Handler runs req, writing what it prints to w.
+type Handler func(ctx context.Context, req Request, w io.Writer) error
+
+// ErrUnreachable tells no server answers on the socket.
+var ErrUnreachable = errors.New("server unreachable")
+
+// errorTrailer carries the error of a command, after its output.
+const errorTrailer = "X-Error"
+
+// Server takes commands on a unix socket, only reachable by users allowed
+// in the data root.
+type Server struct {
+	path string
+	srv  *http.Server
+}
+
+// Listen serves h on the socket at path, replacing a stale one. Callers must
+// hold the Lock of the data root.
+func Listen(path string, h Handler) (*Server, error) {
+	os.Remove(path)
+	// The socket is created with the umask, so others could connect before
+	// Chmod. Listen runs while the server starts, before anything else
+	// creates files.
+	umask := syscall.Umask(0077)
+	l, err := net.Listen("unix", path)
+	syscall.Umask(umask)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	s := &Server{path: path}
+	s.srv = &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req Request
+		if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&req) != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+		zap.L().Info("running command", zap.String("user", req.User), zap.String("collection", req.Collection), zap.Strings("args", req.Args))
+		w.Header().Set("Trailer", errorTrailer)
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if err := h(r.Context(), req, flushWriter{w}); err != nil {
+			w.Header().Set(errorTrailer, strings.ReplaceAll(err.Error(), "\n", " "))
+		}
+	})}
+	go func() {
+		if err := s.srv.Serve(l); err != http.ErrServerClosed {
+			zap.L().Error("admin socket failed", zap.Error(err))
+		}
+	}()
+	return s, nil
+}
+
+// Shutdown stops taking commands, waiting for running ones.
+func (s *Server) Shutdown(ctx context.Context) error {
+	defer os.Remove(s.path)
+	return s.srv.Shutdown(ctx)
+}
+
+// flushWriter sends output as it is printed, for long commands.
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if fl, ok := f.w.(http.Flusher); ok {
+		fl.Flush()
+	}
+	return n, err
+}
+
+// Run has the server listening at path run req, copying its output to w.
+func Run(ctx context.Context, path string, req Request, w io.Writer) error {
+	c := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	hr, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://xbellum/run", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp, err := c.Do(hr)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrUnreachable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(resp.Status)
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return err
+	}
+	if msg := resp.Trailer.Get(errorTrailer); msg != "" {
+		return errors.New(msg)
+	}
+	return nil
+}
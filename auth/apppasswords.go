@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AppPasswordsFile is the name of the app passwords file in a user directory.
+const AppPasswordsFile = "app_passwords"
+
+var labelReg = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// AppPassword is a labelled secret, a user having one per device.
+type AppPassword struct {
+	Label   string
+	Created time.Time
+	hash    string
+}
+
+// AppPasswords are secrets a user can log in with instead of their main
+// password, so that a lost device can be cut off alone. Being random, they
+// are stored as plain SHA-256 hashes, one "label created hash" per line. The
+// file is reloaded when it changes, so revoking from the command line
+// applies to a running server.
+type AppPasswords struct {
+	fn string
+
+	mu      sync.Mutex
+	entries []AppPassword
+	modTime time.Time
+}
+
+func NewAppPasswords(fn string) *AppPasswords {
+	return &AppPasswords{fn: fn}
+}
+
+// load reads the file if it changed since last time.
+func (a *AppPasswords) load() error {
+	fi, err := os.Stat(a.fn)
+	if os.IsNotExist(err) {
+		a.entries, a.modTime = nil, time.Time{}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.ModTime().Equal(a.modTime) && a.entries != nil {
+		return nil
+	}
+	buf, err := ioutil.ReadFile(a.fn)
+	if err != nil {
+		return err
+	}
+	entries := []AppPassword{}
+	for i, line := range strings.Split(string(buf), "\n") {
+		f := strings.Fields(line)
+		if len(f) == 0 {
+			continue
+		}
+		if len(f) != 3 {
+			return fmt.Errorf("%s:%d: expected \"label created hash\"", a.fn, i+1)
+		}
+		created, err := time.Parse(time.RFC3339, f[1])
+		if err != nil {
+			return fmt.Errorf("%s:%d: %s", a.fn, i+1, err)
+		}
+		entries = append(entries, AppPassword{Label: f[0], Created: created, hash: f[2]})
+	}
+	a.entries, a.modTime = entries, fi.ModTime()
+	return nil
+}
+
+func (a *AppPasswords) save() error {
+	b := bytes.NewBuffer([]byte{})
+	for _, e := range a.entries {
+		fmt.Fprintf(b, "%s %s %s\n", e.Label, e.Created.UTC().Format(time.RFC3339), e.hash)
+	}
+	if err := os.MkdirAll(filepath.Dir(a.fn), 0777); err != nil {
+		return err
+	}
+	tmp := a.fn + ".tmp"
+	if err := ioutil.WriteFile(tmp, b.Bytes(), 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, a.fn); err != nil {
+		return err
+	}
+	// Force next load, mtime granularity may hide our own write
+	a.modTime = time.Time{}
+	return nil
+}
+
+func hashSecret(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return "{sha256}" + hex.EncodeToString(h[:])
+}
+
+// Add creates an app password labelled label, and returns it. It can't be
+// retrieved afterwards.
+func (a *AppPasswords) Add(label string) (string, error) {
+	if !labelReg.MatchString(label) {
+		return "", fmt.Errorf("invalid label %q, use letters, digits, dots, dashes and underscores", label)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.load(); err != nil {
+		return "", err
+	}
+	for _, e := range a.entries {
+		if e.Label == label {
+			return "", fmt.Errorf("label %q already exists", label)
+		}
+	}
+	b, err := randomBytes(24)
+	if err != nil {
+		return "", err
+	}
+	password := base64.RawURLEncoding.EncodeToString(b)
+	a.entries = append(a.entries, AppPassword{Label: label, Created: time.Now(), hash: hashSecret(password)})
+	return password, a.save()
+}
+
+// List returns app passwords, without their secret.
+func (a *AppPasswords) List() ([]AppPassword, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.load(); err != nil {
+		return nil, err
+	}
+	return append([]AppPassword(nil), a.entries...), nil
+}
+
+// Revoke removes the app password labelled label.
+func (a *AppPasswords) Revoke(label string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.load(); err != nil {
+		return err
+	}
+	for i, e := range a.entries {
+		if e.Label == label {
+			a.entries = append(a.entries[:i:i], a.entries[i+1:]...)
+			return a.save()
+		}
+	}
+	return fmt.Errorf("unknown label %q", label)
+}
+
+// Check returns the label of the app password matching input, if any.
+func (a *AppPasswords) Check(input string) (string, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.load(); err != nil {
+		return "", false
+	}
+	h := []byte(hashSecret(input))
+	for _, e := range a.entries {
+		if subtle.ConstantTimeCompare(h, []byte(e.hash)) == 1 {
+			return e.Label, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestAppPasswords(t *testing.T) {
+	is := is.New(t)
+
+	fn := filepath.Join(t.TempDir(), "alice", AppPasswordsFile)
+	ap := NewAppPasswords(fn)
+
+	_, ok := ap.Check("")
+	is.True(!ok)
+
+	phone, err := ap.Add("phone")
+	is.NoErr(err)
+	laptop, err := ap.Add("laptop")
+	is.NoErr(err)
+	is.True(phone != laptop)
+
+	_, err = ap.Add("phone")
+	is.True(err != nil) // labels are unique
+	_, err = ap.Add("my phone")
+	is.True(err != nil)
+
+	label, ok := ap.Check(phone)
+	is.True(ok)
+	is.Equal(label, "phone")
+
+	// Another instance, like the CLI next to a running server, sees changes
+	other := NewAppPasswords(fn)
+	list, err := other.List()
+	is.NoErr(err)
+	is.Equal(len(list), 2)
+	is.NoErr(other.Revoke("phone"))
+	is.True(other.Revoke("phone") != nil)
+
+	_, ok = ap.Check(phone)
+	is.True(!ok)
+	label, ok = ap.Check(laptop)
+	is.True(ok)
+	is.Equal(label, "laptop")
+}
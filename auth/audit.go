@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"bufio"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dav-m85/xbellum/logging"
+	"github.com/dav-m85/xbellum/proxy"
+	"go.uber.org/zap"
+)
+
+// AuditFile is the name of the audit log in the data root.
+const AuditFile = "audit.log"
+
+// Audit event kinds.
+const (
+	EventLogin   = "login"
+	EventFailure = "failure"
+	EventLocked  = "locked"
+)
+
+// Event is an audit log entry.
+type Event struct {
+	Time       time.Time `json:"time"`
+	Kind       string    `json:"event"`
+	Username   string    `json:"username,omitempty"`
+	RemoteAddr string    `json:"remote_addr"`
+	// Detail is the failure reason, or the app password used.
+	Detail string `json:"detail,omitempty"`
+}
+
+// Audit appends authentication events to a file, one JSON object per line.
+// WebDAV clients authenticate every request, so a login is only recorded
+// once per LoginEvery for a given user and address, and failures or lockouts
+// once per FailureEvery for a given user, address and reason. Past MaxSize,
+// the file is rotated to <fn>.1.
+type Audit struct {
+	fn           string
+	LoginEvery   time.Duration
+	FailureEvery time.Duration
+	MaxSize      int64
+
+	mu     sync.Mutex
+	seen   map[string]time.Time
+	pruned time.Time
+}
+
+func NewAudit(fn string) *Audit {
+	return &Audit{
+		fn:           fn,
+		LoginEvery:   time.Hour,
+		FailureEvery: time.Minute,
+		MaxSize:      10 << 20,
+		seen:         make(map[string]time.Time),
+	}
+}
+
+// Log records e, its time being set when empty. Failing to write is logged,
+// authentication going on regardless.
+func (a *Audit) Log(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	every := a.FailureEvery
+	if e.Kind == EventLogin {
+		every = a.LoginEvery
+	}
+	k := e.Kind + " " + e.Username + " " + e.RemoteAddr + " " + e.Detail
+	if last, ok := a.seen[k]; ok && e.Time.Sub(last) < every {
+		return
+	}
+	a.seen[k] = e.Time
+	a.prune(e.Time)
+
+	if err := a.write(e); err != nil {
+		zap.L().Error("audit log write failed", zap.Error(err))
+	}
+}
+
+// prune forgets events older than any throttling window, once per window.
+func (a *Audit) prune(now time.Time) {
+	window := a.LoginEvery
+	if a.FailureEvery > window {
+		window = a.FailureEvery
+	}
+	if now.Sub(a.pruned) < window {
+		return
+	}
+	for k, t := range a.seen {
+		if now.Sub(t) >= window {
+			delete(a.seen, k)
+		}
+	}
+	a.pruned = now
+}
+
+func (a *Audit) write(e Event) error {
+	buf, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(a.fn), 0777); err != nil {
+		return err
+	}
+	if fi, err := os.Stat(a.fn); err == nil && a.MaxSize > 0 && fi.Size() >= a.MaxSize {
+		if err := os.Rename(a.fn, a.fn+".1"); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(a.fn, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(buf, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Events returns the last n events, newest first.
+func (a *Audit) Events(n int) ([]Event, error) {
+	var events []Event
+	for _, fn := range []string{a.fn + ".1", a.fn} {
+		var err error
+		if events, err = readEvents(fn, events, n); err != nil {
+			return nil, err
+		}
+	}
+	if len(events) > n {
+		events = events[len(events)-n:]
+	}
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	return events, nil
+}
+
+// readEvents appends the events of fn to events, keeping at least the last n.
+func readEvents(fn string, events []Event, n int) ([]Event, error) {
+	f, err := os.Open(fn)
+	if os.IsNotExist(err) {
+		return events, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var e Event
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			continue
+		}
+		events = append(events, e)
+		if len(events) > 2*n {
+			events = append(events[:0], events[len(events)-n:]...)
+		}
+	}
+	return events, sc.Err()
+}
+
+var auditTpl = template.Must(template.New("audit").Parse(`
+<html>
+<body>
+<p><a href="{{.Prefix}}/info">Bookmarks</a></p>
+<h2>Authentication log</h2>
+<form method="get">
+	<select name="event">
+		<option value="">all events</option>
+		{{range .Kinds}}<option{{if eq . $.Kind}} selected{{end}}>{{.}}</option>{{end}}
+	</select>
+	<input type="submit" value="Filter">
+</form>
+<table>
+<tr><th>Time</th><th>Event</th><th>User</th><th>Address</th><th></th></tr>
+{{range .Events}}
+<tr{{if ne .Kind "login"}} style="color: red"{{end}}>
+	<td>{{.Time.Format "2006-01-02 15:04:05"}}</td><td>{{.Kind}}</td><td>{{.Username}}</td><td>{{.RemoteAddr}}</td><td>{{.Detail}}</td>
+</tr>
+{{end}}
+</table>
+</body>
+</html>
+`))
+
+// ServeHTTP shows the last events, n of them if set, filtered by event kind.
+func (a *Audit) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n, err := strconv.Atoi(r.URL.Query().Get("n"))
+	if err != nil || n <= 0 {
+		n = 200
+	}
+	kind := r.URL.Query().Get("event")
+	events, err := a.Events(n)
+	if err != nil {
+		logging.From(r.Context()).Error("audit log read failed", zap.Error(err))
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	if kind != "" {
+		var filtered []Event
+		for _, e := range events {
+			if e.Kind == kind {
+				filtered = append(filtered, e)
+			}
+		}
+		events = filtered
+	}
+	err = auditTpl.Execute(w, map[string]interface{}{
+		"Prefix": proxy.Prefix(r),
+		"Events": events,
+		"Kind":   kind,
+		"Kinds":  []string{EventLogin, EventFailure, EventLocked},
+	})
+	if err != nil {
+		logging.From(r.Context()).Error("rendering failed", zap.Error(err))
+	}
+}
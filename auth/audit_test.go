@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestAudit(t *testing.T) {
+	is := is.New(t)
+
+	a := NewAudit(filepath.Join(t.TempDir(), AuditFile))
+	events, err := a.Events(10)
+	is.NoErr(err)
+	is.Equal(len(events), 0)
+
+	now := time.Now()
+	a.Log(Event{Time: now, Kind: EventFailure, Username: "alice", RemoteAddr: "10.0.0.1", Detail: "invalid password"})
+	a.Log(Event{Time: now, Kind: EventLogin, Username: "alice", RemoteAddr: "10.0.0.1"})
+	// Repeated logins are recorded once in a while
+	a.Log(Event{Time: now.Add(time.Minute), Kind: EventLogin, Username: "alice", RemoteAddr: "10.0.0.1"})
+	a.Log(Event{Time: now.Add(time.Minute), Kind: EventLogin, Username: "alice", RemoteAddr: "10.0.0.2"})
+	a.Log(Event{Time: now.Add(2 * time.Hour), Kind: EventLogin, Username: "alice", RemoteAddr: "10.0.0.1"})
+
+	events, err = a.Events(10)
+	is.NoErr(err)
+	is.Equal(len(events), 4)
+	is.Equal(events[3].Kind, EventFailure)
+	is.Equal(events[3].Detail, "invalid password")
+	is.Equal(events[0].RemoteAddr, "10.0.0.1")
+
+	events, err = a.Events(2)
+	is.NoErr(err)
+	is.Equal(len(events), 2)
+	is.Equal(events[1].RemoteAddr, "10.0.0.2")
+
+	// Failures and lockouts are throttled too
+	for i := 0; i < 100; i++ {
+		a.Log(Event{Time: now.Add(3 * time.Hour), Kind: EventFailure, Username: "bob", RemoteAddr: "10.0.0.3", Detail: "invalid password"})
+		a.Log(Event{Time: now.Add(3 * time.Hour), Kind: EventLocked, RemoteAddr: "10.0.0.3"})
+	}
+	events, err = a.Events(10)
+	is.NoErr(err)
+	is.Equal(len(events), 6)
+	// Old events are forgotten
+	is.Equal(len(a.seen), 2)
+
+	w := httptest.NewRecorder()
+	a.ServeHTTP(w, httptest.NewRequest("GET", "/info/audit?event=failure", nil))
+	is.True(strings.Contains(w.Body.String(), "invalid password"))
+	is.True(!strings.Contains(w.Body.String(), "10.0.0.2"))
+}
+
+func TestAuditRotate(t *testing.T) {
+	is := is.New(t)
+
+	fn := filepath.Join(t.TempDir(), AuditFile)
+	a := NewAudit(fn)
+	a.MaxSize = 1000
+	now := time.Now()
+	for i := 0; i < 50; i++ {
+		a.Log(Event{Time: now.Add(time.Duration(i) * time.Hour), Kind: EventLogin, Username: "alice", RemoteAddr: "10.0.0.1"})
+	}
+	fi, err := os.Stat(fn)
+	is.NoErr(err)
+	is.True(fi.Size() < 1100)
+	_, err = os.Stat(fn + ".1")
+	is.NoErr(err)
+
+	// The rotated file still shows up
+	events, err := a.Events(15)
+	is.NoErr(err)
+	is.Equal(len(events), 15)
+	is.True(events[0].Time.Equal(now.Add(49 * time.Hour)))
+}
@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter slows down password guessing. Past Free failures, a key (an IP or
+// a username) is locked for Base, doubling on every further failure up to
+// Max. A success clears the key, and keys are forgotten Max after their last
+// failure.
+type Limiter struct {
+	Free int
+	Base time.Duration
+	Max  time.Duration
+
+	mu   sync.Mutex
+	keys map[string]*failures
+	now  func() time.Time
+}
+
+type failures struct {
+	count int
+	last  time.Time
+	until time.Time
+}
+
+func NewLimiter() *Limiter {
+	return &Limiter{
+		Free: 5,
+		Base: time.Second,
+		Max:  15 * time.Minute,
+		keys: make(map[string]*failures),
+		now:  time.Now,
+	}
+}
+
+// Locked returns how long key has to wait before trying again, 0 if it can
+// try now.
+func (l *Limiter) Locked(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, ok := l.keys[key]
+	if !ok {
+		return 0
+	}
+	wait := f.until.Sub(l.now())
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// Fail records a failure for key, and returns the lock it now has.
+func (l *Limiter) Fail(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.expire(now)
+	f, ok := l.keys[key]
+	if !ok {
+		f = &failures{}
+		l.keys[key] = f
+	}
+	f.count++
+	f.last = now
+	if f.count <= l.Free {
+		return 0
+	}
+	d := l.Base
+	for i := l.Free + 1; i < f.count && d < l.Max; i++ {
+		d *= 2
+	}
+	if d > l.Max {
+		d = l.Max
+	}
+	f.until = now.Add(d)
+	return d
+}
+
+// Success clears failures of key.
+func (l *Limiter) Success(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.keys, key)
+}
+
+func (l *Limiter) expire(now time.Time) {
+	for k, f := range l.keys {
+		if now.Sub(f.last) > l.Max && now.After(f.until) {
+			delete(l.keys, k)
+		}
+	}
+}
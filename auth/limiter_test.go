@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestLimiter(t *testing.T) {
+	is := is.New(t)
+
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := NewLimiter()
+	l.Free = 2
+	l.Max = 10 * time.Second
+	l.now = func() time.Time { return now }
+
+	is.Equal(l.Fail("ip"), time.Duration(0))
+	is.Equal(l.Fail("ip"), time.Duration(0))
+	is.Equal(l.Locked("ip"), time.Duration(0))
+
+	// Backoff doubles up to Max
+	is.Equal(l.Fail("ip"), time.Second)
+	is.Equal(l.Locked("ip"), time.Second)
+	is.Equal(l.Locked("other"), time.Duration(0))
+	is.Equal(l.Fail("ip"), 2*time.Second)
+	is.Equal(l.Fail("ip"), 4*time.Second)
+	is.Equal(l.Fail("ip"), 8*time.Second)
+	is.Equal(l.Fail("ip"), 10*time.Second)
+	is.Equal(l.Fail("ip"), 10*time.Second)
+
+	now = now.Add(4 * time.Second)
+	is.Equal(l.Locked("ip"), 6*time.Second)
+
+	l.Success("ip")
+	is.Equal(l.Locked("ip"), time.Duration(0))
+
+	// Failures are forgotten after a while
+	l.Fail("ip")
+	l.Fail("ip")
+	now = now.Add(time.Minute)
+	l.Fail("other")
+	is.Equal(l.Fail("ip"), time.Duration(0))
+}
@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Schemes lists supported hashing schemes, the first one being the default.
+var Schemes = []string{"argon2id", "bcrypt", "scrypt"}
+
+// Argon2id and scrypt parameters used when hashing. Checking uses the ones
+// stored along the hash.
+const (
+	argonTime    = 3
+	argonMemory  = 64 * 1024
+	argonThreads = 2
+	argonKeyLen  = 32
+
+	scryptLogN   = 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+
+	saltLen = 16
+)
+
+var b64 = base64.RawStdEncoding
+
+// Hash returns password hashed with scheme, prefixed with {scheme} so that
+// Check can tell them apart. argon2id and scrypt hashes use PHC strings.
+func Hash(scheme, password string) (string, error) {
+	switch scheme {
+	case "bcrypt":
+		h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return "", err
+		}
+		return "{bcrypt}" + string(h), nil
+	case "argon2id":
+		salt, err := randomBytes(saltLen)
+		if err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, argonTime, argonMemory, argonThreads, argonKeyLen)
+		return fmt.Sprintf("{argon2id}$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+			argon2.Version, argonMemory, argonTime, argonThreads, b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+	case "scrypt":
+		salt, err := randomBytes(saltLen)
+		if err != nil {
+			return "", err
+		}
+		key, err := scrypt.Key([]byte(password), salt, 1<<scryptLogN, scryptR, scryptP, scryptKeyLen)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("{scrypt}$scrypt$ln=%d,r=%d,p=%d$%s$%s",
+			scryptLogN, scryptR, scryptP, b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+	}
+	return "", fmt.Errorf("unknown scheme %q, expected one of %s", scheme, strings.Join(Schemes, ", "))
+}
+
+// Check tells if input matches saved, saved being a Hash result or plain
+// text.
+func Check(saved, input string) bool {
+	switch {
+	case strings.HasPrefix(saved, "{bcrypt}"):
+		savedPassword := strings.TrimPrefix(saved, "{bcrypt}")
+		return bcrypt.CompareHashAndPassword([]byte(savedPassword), []byte(input)) == nil
+	case strings.HasPrefix(saved, "{argon2id}"):
+		return checkArgon2id(strings.TrimPrefix(saved, "{argon2id}"), input)
+	case strings.HasPrefix(saved, "{scrypt}"):
+		return checkScrypt(strings.TrimPrefix(saved, "{scrypt}"), input)
+	}
+	return subtle.ConstantTimeCompare([]byte(saved), []byte(input)) == 1
+}
+
+// phc splits a $id$...$salt$hash string, returning the middle parts.
+func phc(s, id string, n int) (params []string, salt, key []byte, ok bool) {
+	parts := strings.Split(s, "$")
+	if len(parts) != n+4 || parts[0] != "" || parts[1] != id {
+		return nil, nil, nil, false
+	}
+	salt, err := b64.DecodeString(parts[n+2])
+	if err != nil {
+		return nil, nil, nil, false
+	}
+	key, err = b64.DecodeString(parts[n+3])
+	if err != nil || len(key) == 0 {
+		return nil, nil, nil, false
+	}
+	return parts[2 : n+2], salt, key, true
+}
+
+func checkArgon2id(s, input string) bool {
+	params, salt, key, ok := phc(s, "argon2id", 2)
+	if !ok {
+		return false
+	}
+	var version int
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(params[0], "v=%d", &version); err != nil || version != argon2.Version {
+		return false
+	}
+	if _, err := fmt.Sscanf(params[1], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false
+	}
+	got := argon2.IDKey([]byte(input), salt, time, memory, threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(got, key) == 1
+}
+
+func checkScrypt(s, input string) bool {
+	params, salt, key, ok := phc(s, "scrypt", 1)
+	if !ok {
+		return false
+	}
+	var logN uint
+	var r, p int
+	if _, err := fmt.Sscanf(params[0], "ln=%d,r=%d,p=%d", &logN, &r, &p); err != nil || logN > 30 {
+		return false
+	}
+	got, err := scrypt.Key([]byte(input), salt, 1<<logN, r, p, len(key))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(got, key) == 1
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	return b, err
+}
@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestHash(t *testing.T) {
+	is := is.New(t)
+
+	for _, scheme := range Schemes {
+		h, err := Hash(scheme, "pa")
+		is.NoErr(err)
+		is.True(strings.HasPrefix(h, "{"+scheme+"}"))
+		is.True(Check(h, "pa"))
+		is.True(!Check(h, "pb"))
+		is.True(!Check(h, ""))
+	}
+
+	_, err := Hash("md5", "pa")
+	is.True(err != nil)
+
+	// Plain text still works
+	is.True(Check("pa", "pa"))
+	is.True(!Check("pa", "pab"))
+
+	// As do hashes made elsewhere
+	is.True(Check("{bcrypt}$2a$04$nkXvUTZJ5PDedPpLDMU4Ee.UfIAormQ9/qBpCEbgsjeRj2CvMsBnO", "pa"))
+
+	// Broken hashes match nothing
+	is.True(!Check("{argon2id}$argon2id$v=19$m=65536$abc$def", "pa"))
+	is.True(!Check("{scrypt}$scrypt$ln=99,r=8,p=1$YWJj$ZGVm", "pa"))
+}
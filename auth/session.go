@@ -0,0 +1,220 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dav-m85/xbellum/proxy"
+)
+
+// KeyFile is the name of the session signing key in the data root.
+const KeyFile = "session.key"
+
+// EndedFile is the name of the ended sessions file in the data root.
+const EndedFile = "sessions.ended"
+
+// SessionCookie is the name of the web UI session cookie.
+const SessionCookie = "xbellum_session"
+
+// LoadKey reads a signing key from fn, creating it when missing, so that
+// sessions survive restarts.
+func LoadKey(fn string) ([]byte, error) {
+	key, err := ioutil.ReadFile(fn)
+	if err == nil && len(key) >= 32 {
+		return key, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if key, err = randomBytes(32); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(fn), 0777); err != nil {
+		return nil, err
+	}
+	return key, ioutil.WriteFile(fn, key, 0600)
+}
+
+// Session is a user logged in the web UI.
+type Session struct {
+	ID       string
+	Username string
+	Expires  time.Time
+}
+
+// Sessions hands out signed session cookies. Cookies hold the whole session,
+// ended ones being remembered until they expire, one "id expires" per line
+// when loaded from a file.
+type Sessions struct {
+	key    []byte
+	fn     string
+	MaxAge time.Duration
+
+	mu    sync.Mutex
+	ended map[string]time.Time
+}
+
+func NewSessions(key []byte) *Sessions {
+	return &Sessions{
+		key:    key,
+		MaxAge: 7 * 24 * time.Hour,
+		ended:  make(map[string]time.Time),
+	}
+}
+
+// LoadSessions returns Sessions remembering ended ones in fn, so that
+// cookies of logged out sessions stay refused after a restart.
+func LoadSessions(key []byte, fn string) (*Sessions, error) {
+	s := NewSessions(key)
+	s.fn = fn
+	d, err := ioutil.ReadFile(fn)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sc := bufio.NewScanner(bytes.NewReader(d))
+	for sc.Scan() {
+		var id string
+		var exp int64
+		if _, err := fmt.Sscanf(sc.Text(), "%s %d", &id, &exp); err != nil {
+			return nil, fmt.Errorf("%s: invalid line %q", fn, sc.Text())
+		}
+		s.ended[id] = time.Unix(exp, 0)
+	}
+	return s, sc.Err()
+}
+
+func (s *Sessions) sign(parts ...string) []byte {
+	m := hmac.New(sha256.New, s.key)
+	m.Write([]byte(strings.Join(parts, "\x00")))
+	return m.Sum(nil)
+}
+
+// New starts a session for username, setting its cookie on w.
+func (s *Sessions) New(w http.ResponseWriter, r *http.Request, username string) (Session, error) {
+	id, err := randomBytes(16)
+	if err != nil {
+		return Session{}, err
+	}
+	sess := Session{
+		ID:       hex.EncodeToString(id),
+		Username: username,
+		Expires:  time.Now().Add(s.MaxAge).Truncate(time.Second),
+	}
+	v := sess.ID + "|" + strconv.FormatInt(sess.Expires.Unix(), 10) + "|" + sess.Username
+	enc := base64.RawURLEncoding
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    enc.EncodeToString([]byte(v)) + "." + enc.EncodeToString(s.sign("session", v)),
+		Path:     proxy.Prefix(r) + "/",
+		Expires:  sess.Expires,
+		HttpOnly: true,
+		Secure:   proxy.Secure(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return sess, nil
+}
+
+// Get returns the session of r, if its cookie is valid.
+func (s *Sessions) Get(r *http.Request) (Session, bool) {
+	c, err := r.Cookie(SessionCookie)
+	if err != nil {
+		return Session{}, false
+	}
+	enc := base64.RawURLEncoding
+	i := strings.IndexByte(c.Value, '.')
+	if i < 0 {
+		return Session{}, false
+	}
+	v, err := enc.DecodeString(c.Value[:i])
+	if err != nil {
+		return Session{}, false
+	}
+	sig, err := enc.DecodeString(c.Value[i+1:])
+	if err != nil || !hmac.Equal(sig, s.sign("session", string(v))) {
+		return Session{}, false
+	}
+	parts := strings.SplitN(string(v), "|", 3)
+	if len(parts) != 3 {
+		return Session{}, false
+	}
+	exp, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return Session{}, false
+	}
+	sess := Session{ID: parts[0], Expires: time.Unix(exp, 0), Username: parts[2]}
+	if time.Now().After(sess.Expires) {
+		return Session{}, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.ended[sess.ID]; ok {
+		return Session{}, false
+	}
+	return sess, true
+}
+
+// End ends sess and clears its cookie. The session is over even when
+// remembering it in the file fails.
+func (s *Sessions) End(w http.ResponseWriter, r *http.Request, sess Session) error {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Path:     proxy.Prefix(r) + "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for id, exp := range s.ended {
+		if now.After(exp) {
+			delete(s.ended, id)
+		}
+	}
+	s.ended[sess.ID] = sess.Expires
+	if s.fn == "" {
+		return nil
+	}
+	return s.save()
+}
+
+func (s *Sessions) save() error {
+	b := bytes.NewBuffer([]byte{})
+	for id, exp := range s.ended {
+		fmt.Fprintf(b, "%s %d\n", id, exp.Unix())
+	}
+	tmp := s.fn + ".tmp"
+	if err := ioutil.WriteFile(tmp, b.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.fn)
+}
+
+// Token returns the CSRF token forms of username must carry, tied to a
+// session ID, empty for Basic auth.
+func (s *Sessions) Token(username, id string) string {
+	return hex.EncodeToString(s.sign("csrf", username, id))
+}
+
+// CheckToken tells if token is the one Token returns.
+func (s *Sessions) CheckToken(username, id, token string) bool {
+	got, err := hex.DecodeString(token)
+	return err == nil && hmac.Equal(got, s.sign("csrf", username, id))
+}
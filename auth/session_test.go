@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestSessions(t *testing.T) {
+	is := is.New(t)
+
+	fn := filepath.Join(t.TempDir(), KeyFile)
+	key, err := LoadKey(fn)
+	is.NoErr(err)
+	again, err := LoadKey(fn)
+	is.NoErr(err)
+	is.Equal(key, again)
+
+	s := NewSessions(key)
+	w := httptest.NewRecorder()
+	sess, err := s.New(w, httptest.NewRequest("POST", "/login", nil), "alice")
+	is.NoErr(err)
+	cookie := w.Result().Cookies()[0]
+	is.True(cookie.HttpOnly)
+
+	r := httptest.NewRequest("GET", "/info", nil)
+	r.AddCookie(cookie)
+	got, ok := s.Get(r)
+	is.True(ok)
+	is.Equal(got.Username, "alice")
+	is.Equal(got.ID, sess.ID)
+
+	// Tampered cookies are refused
+	forged := *cookie
+	forged.Value = "x" + forged.Value
+	r = httptest.NewRequest("GET", "/info", nil)
+	r.AddCookie(&forged)
+	_, ok = s.Get(r)
+	is.True(!ok)
+	r = httptest.NewRequest("GET", "/info", nil)
+	r.AddCookie(cookie)
+	_, ok = NewSessions([]byte("another key of at least 32 bytes")).Get(r)
+	is.True(!ok)
+
+	// Tokens are tied to user and session
+	token := s.Token("alice", sess.ID)
+	is.True(s.CheckToken("alice", sess.ID, token))
+	is.True(!s.CheckToken("bob", sess.ID, token))
+	is.True(!s.CheckToken("alice", "", token))
+	is.True(!s.CheckToken("alice", sess.ID, ""))
+
+	// Ended sessions are over
+	is.NoErr(s.End(httptest.NewRecorder(), r, sess))
+	r = httptest.NewRequest("GET", "/info", nil)
+	r.AddCookie(cookie)
+	_, ok = s.Get(r)
+	is.True(!ok)
+}
+
+func TestSessionsEnded(t *testing.T) {
+	is := is.New(t)
+	fn := filepath.Join(t.TempDir(), EndedFile)
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	s, err := LoadSessions(key, fn)
+	is.NoErr(err)
+	w := httptest.NewRecorder()
+	sess, err := s.New(w, httptest.NewRequest("POST", "/login", nil), "alice")
+	is.NoErr(err)
+	cookie := w.Result().Cookies()[0]
+	is.NoErr(s.End(httptest.NewRecorder(), httptest.NewRequest("POST", "/logout", nil), sess))
+
+	// Still over after a restart
+	s, err = LoadSessions(key, fn)
+	is.NoErr(err)
+	r := httptest.NewRequest("GET", "/info", nil)
+	r.AddCookie(cookie)
+	_, ok := s.Get(r)
+	is.True(!ok)
+}
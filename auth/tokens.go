@@ -0,0 +1,232 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TokensFile is the name of the API tokens file in the data root.
+const TokensFile = "api_tokens"
+
+// TokenPrefix starts every API token, telling them apart from passwords.
+const TokenPrefix = "xbt_"
+
+// Token scopes.
+const (
+	ScopeRead    = "read"
+	ScopeWrite   = "write"
+	ScopeRestore = "restore"
+	ScopeAdmin   = "admin"
+)
+
+// Scopes lists valid token scopes.
+var Scopes = []string{ScopeRead, ScopeWrite, ScopeRestore, ScopeAdmin}
+
+// ParseScopes checks a comma separated list of scopes.
+func ParseScopes(s string) ([]string, error) {
+	var scopes []string
+	for _, sc := range strings.Split(s, ",") {
+		sc = strings.TrimSpace(sc)
+		if sc == "" {
+			continue
+		}
+		valid := false
+		for _, v := range Scopes {
+			valid = valid || v == sc
+		}
+		if !valid {
+			return nil, fmt.Errorf("unknown scope %q, expected %s", sc, strings.Join(Scopes, ", "))
+		}
+		scopes = append(scopes, sc)
+	}
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("no scope given")
+	}
+	sort.Strings(scopes)
+	return scopes, nil
+}
+
+// Token is a long-lived API credential of a user, limited to some scopes.
+type Token struct {
+	Username string
+	Label    string
+	Scopes   []string
+	Created  time.Time
+	hash     string
+}
+
+// Tokens are API tokens of all users, used as "Authorization: Bearer". Like
+// app passwords they are stored hashed, one "username label created scopes
+// hash" per line, and reloaded when the file changes.
+type Tokens struct {
+	fn string
+
+	mu      sync.Mutex
+	entries []Token
+	modTime time.Time
+}
+
+func NewTokens(fn string) *Tokens {
+	return &Tokens{fn: fn}
+}
+
+func (t *Tokens) load() error {
+	fi, err := os.Stat(t.fn)
+	if os.IsNotExist(err) {
+		t.entries, t.modTime = nil, time.Time{}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.ModTime().Equal(t.modTime) && t.entries != nil {
+		return nil
+	}
+	buf, err := ioutil.ReadFile(t.fn)
+	if err != nil {
+		return err
+	}
+	entries := []Token{}
+	for i, line := range strings.Split(string(buf), "\n") {
+		f := strings.Fields(line)
+		if len(f) == 0 {
+			continue
+		}
+		if len(f) != 5 {
+			return fmt.Errorf("%s:%d: expected \"username label created scopes hash\"", t.fn, i+1)
+		}
+		created, err := time.Parse(time.RFC3339, f[2])
+		if err != nil {
+			return fmt.Errorf("%s:%d: %s", t.fn, i+1, err)
+		}
+		// Username is - for the shared secret user
+		username := f[0]
+		if username == "-" {
+			username = ""
+		}
+		entries = append(entries, Token{
+			Username: username,
+			Label:    f[1],
+			Created:  created,
+			Scopes:   strings.Split(f[3], ","),
+			hash:     f[4],
+		})
+	}
+	t.entries, t.modTime = entries, fi.ModTime()
+	return nil
+}
+
+func (t *Tokens) save() error {
+	b := bytes.NewBuffer([]byte{})
+	for _, e := range t.entries {
+		username := e.Username
+		if username == "" {
+			username = "-"
+		}
+		fmt.Fprintf(b, "%s %s %s %s %s\n", username, e.Label, e.Created.UTC().Format(time.RFC3339),
+			strings.Join(e.Scopes, ","), e.hash)
+	}
+	tmp := t.fn + ".tmp"
+	if err := ioutil.WriteFile(tmp, b.Bytes(), 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, t.fn); err != nil {
+		return err
+	}
+	t.modTime = time.Time{}
+	return nil
+}
+
+// Add creates a token for username, and returns it. It can't be retrieved
+// afterwards.
+func (t *Tokens) Add(username, label string, scopes []string) (string, error) {
+	if !labelReg.MatchString(label) {
+		return "", fmt.Errorf("invalid label %q, use letters, digits, dots, dashes and underscores", label)
+	}
+	scopes, err := ParseScopes(strings.Join(scopes, ","))
+	if err != nil {
+		return "", err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.load(); err != nil {
+		return "", err
+	}
+	for _, e := range t.entries {
+		if e.Username == username && e.Label == label {
+			return "", fmt.Errorf("label %q already exists", label)
+		}
+	}
+	b, err := randomBytes(24)
+	if err != nil {
+		return "", err
+	}
+	token := TokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	t.entries = append(t.entries, Token{
+		Username: username,
+		Label:    label,
+		Scopes:   scopes,
+		Created:  time.Now(),
+		hash:     hashSecret(token),
+	})
+	return token, t.save()
+}
+
+// List returns tokens of username, without their secret.
+func (t *Tokens) List(username string) ([]Token, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.load(); err != nil {
+		return nil, err
+	}
+	var tokens []Token
+	for _, e := range t.entries {
+		if e.Username == username {
+			tokens = append(tokens, e)
+		}
+	}
+	return tokens, nil
+}
+
+// Revoke removes the token of username labelled label.
+func (t *Tokens) Revoke(username, label string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.load(); err != nil {
+		return err
+	}
+	for i, e := range t.entries {
+		if e.Username == username && e.Label == label {
+			t.entries = append(t.entries[:i:i], t.entries[i+1:]...)
+			return t.save()
+		}
+	}
+	return fmt.Errorf("unknown label %q", label)
+}
+
+// Check returns the token matching input, if any.
+func (t *Tokens) Check(input string) (Token, bool) {
+	if !strings.HasPrefix(input, TokenPrefix) {
+		return Token{}, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.load(); err != nil {
+		return Token{}, false
+	}
+	h := []byte(hashSecret(input))
+	for _, e := range t.entries {
+		if subtle.ConstantTimeCompare(h, []byte(e.hash)) == 1 {
+			return e, true
+		}
+	}
+	return Token{}, false
+}
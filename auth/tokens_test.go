@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestTokens(t *testing.T) {
+	is := is.New(t)
+
+	tokens := NewTokens(filepath.Join(t.TempDir(), TokensFile))
+
+	_, err := tokens.Add("alice", "ci", []string{"deploy"})
+	is.True(err != nil)
+	_, err = tokens.Add("alice", "ci", nil)
+	is.True(err != nil)
+
+	ci, err := tokens.Add("alice", "ci", []string{ScopeWrite, ScopeRead})
+	is.NoErr(err)
+	is.True(strings.HasPrefix(ci, TokenPrefix))
+	shared, err := tokens.Add("", "ci", []string{ScopeRead})
+	is.NoErr(err)
+	_, err = tokens.Add("alice", "ci", []string{ScopeRead})
+	is.True(err != nil) // labels are unique per user
+
+	tok, ok := tokens.Check(ci)
+	is.True(ok)
+	is.Equal(tok.Username, "alice")
+	is.Equal(tok.Scopes, []string{ScopeRead, ScopeWrite})
+	_, ok = tokens.Check(strings.TrimPrefix(ci, TokenPrefix))
+	is.True(!ok)
+
+	// Survives a reload, the shared secret user included
+	tokens = NewTokens(tokens.fn)
+	tok, ok = tokens.Check(shared)
+	is.True(ok)
+	is.Equal(tok.Username, "")
+	list, err := tokens.List("alice")
+	is.NoErr(err)
+	is.Equal(len(list), 1)
+
+	is.NoErr(tokens.Revoke("alice", "ci"))
+	_, ok = tokens.Check(ci)
+	is.True(!ok)
+	_, ok = tokens.Check(shared)
+	is.True(ok)
+}
@@ -0,0 +1,130 @@
+package certs
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DefaultInterval is how often files are checked for changes.
+const DefaultInterval = 10 * time.Second
+
+// Reloader serves a certificate read from a cert and key file pair, reloading
+// it when either changes on disk, for certificates renewed by another tool.
+// Files are checked during handshakes, at most once per Interval.
+type Reloader struct {
+	certFile, keyFile string
+	Interval          time.Duration
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+// NewReloader loads the certificate, failing if it can't.
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		Interval: DefaultInterval,
+	}
+	mt, err := r.lastModified()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(mt); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Reloader) lastModified() (time.Time, error) {
+	var last time.Time
+	for _, fn := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(fn)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(last) {
+			last = fi.ModTime()
+		}
+	}
+	return last, nil
+}
+
+func (r *Reloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert, r.modTime = &cert, modTime
+	return nil
+}
+
+// GetCertificate is meant for tls.Config. When reloading fails, like when
+// files are half written, the previous certificate keeps being served.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.checked) >= r.Interval {
+		r.checked = time.Now()
+		mt, err := r.lastModified()
+		if err == nil && !mt.Equal(r.modTime) {
+			err = r.load(mt)
+			if err == nil {
+				zap.L().Info("certificate reloaded", zap.String("file", r.certFile))
+			}
+		}
+		if err != nil {
+			zap.L().Warn("keeping previous certificate", zap.Error(err))
+		}
+	}
+	return r.cert, nil
+}
+
+// TLSConfig returns a server configuration serving the reloaded certificate.
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.GetCertificate,
+	}
+}
+
+// HSTS tells browsers to stick to HTTPS for maxAge on every response of h.
+func HSTS(h http.Handler, maxAge time.Duration) http.Handler {
+	v := "max-age=" + strconv.Itoa(int(maxAge.Seconds()))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", v)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// RedirectHTTPS sends every request to the same URL over HTTPS, on the port
+// of httpsAddr.
+func RedirectHTTPS(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]")
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		u := *r.URL
+		u.Scheme, u.Host = "https", host
+		http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,121 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+// writeCert writes a self-signed certificate for localhost, with serial.
+func writeCert(t *testing.T, certFile, keyFile string, serial int64) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kb, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kb}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serial connects to addr and returns the serial of the served certificate.
+func serial(t *testing.T, addr string) int64 {
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+}
+
+func TestReloader(t *testing.T) {
+	is := is.New(t)
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	_, err := NewReloader(certFile, keyFile)
+	is.True(os.IsNotExist(err))
+
+	writeCert(t, certFile, keyFile, 1)
+	r, err := NewReloader(certFile, keyFile)
+	is.NoErr(err)
+	r.Interval = 0
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", r.TLSConfig())
+	is.NoErr(err)
+	srv := &http.Server{Handler: http.NotFoundHandler()}
+	go srv.Serve(ln)
+	defer srv.Close()
+	addr := ln.Addr().String()
+
+	is.Equal(serial(t, addr), int64(1))
+
+	// Renewed on disk
+	writeCert(t, certFile, keyFile, 2)
+	future := time.Now().Add(time.Minute)
+	is.NoErr(os.Chtimes(certFile, future, future))
+	is.Equal(serial(t, addr), int64(2))
+
+	// Broken files keep the previous certificate
+	is.NoErr(ioutil.WriteFile(keyFile, []byte("garbage"), 0600))
+	future = future.Add(time.Minute)
+	is.NoErr(os.Chtimes(keyFile, future, future))
+	is.Equal(serial(t, addr), int64(2))
+}
+
+func TestRedirect(t *testing.T) {
+	is := is.New(t)
+
+	for addr, want := range map[string]string{
+		":443":           "https://example.com/info?c=work",
+		":8443":          "https://example.com:8443/info?c=work",
+		"127.0.0.1:8443": "https://example.com:8443/info?c=work",
+	} {
+		w := httptest.NewRecorder()
+		RedirectHTTPS(addr).ServeHTTP(w, httptest.NewRequest("GET", "http://example.com:8080/info?c=work", nil))
+		is.Equal(w.Code, http.StatusMovedPermanently)
+		is.Equal(w.Header().Get("Location"), want)
+	}
+
+	w := httptest.NewRecorder()
+	HSTS(http.NotFoundHandler(), 365*24*time.Hour).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	is.Equal(w.Header().Get("Strict-Transport-Security"), "max-age=31536000")
+}
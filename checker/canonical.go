@@ -0,0 +1,70 @@
+package checker
+
+import (
+	"context"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+)
+
+var (
+	linkReg  = regexp.MustCompile(`(?is)<link\b[^>]*>`)
+	relReg   = regexp.MustCompile(`(?i)\brel\s*=\s*["']?canonical\b`)
+	hrefAttr = regexp.MustCompile(`(?i)\bhref\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+)
+
+// canonical returns the <link rel=canonical> of a page, resolved against
+// base, empty when there is none.
+func canonical(base *url.URL, body []byte) string {
+	for _, tag := range linkReg.FindAll(body, -1) {
+		if !relReg.Match(tag) {
+			continue
+		}
+		m := hrefAttr.FindSubmatch(tag)
+		if m == nil {
+			continue
+		}
+		href := string(m[1]) + string(m[2]) + string(m[3])
+		u, err := base.Parse(html.UnescapeString(href))
+		if err != nil || u.Scheme != "http" && u.Scheme != "https" {
+			continue
+		}
+		return u.String()
+	}
+	return ""
+}
+
+// Canonicals fetches pages of urls for the canonical URL they declare with
+// <link rel=canonical>, or where they redirect to otherwise. Pages telling
+// neither, or failing, are left out of the result, keyed by URL.
+func (c *Checker) Canonicals(ctx context.Context, urls []string) map[string]string {
+	found := make(map[string]string)
+	for _, res := range c.all(ctx, urls, c.tryCanonical) {
+		if res.Err != nil || res.Code >= 300 {
+			continue
+		}
+		can := res.Canonical
+		if can == "" {
+			can = res.FinalURL
+		}
+		if can != "" && can != res.URL {
+			found[res.URL] = can
+		}
+	}
+	return found
+}
+
+// tryCanonical gets the page, looking for its canonical link.
+func (c *Checker) tryCanonical(ctx context.Context, u *url.URL) Result {
+	res, body := c.request(ctx, http.MethodGet, u.String(), true)
+	if res.Err != nil || res.Code >= 300 {
+		return res
+	}
+	base := u
+	if final, err := url.Parse(res.FinalURL); err == nil && res.FinalURL != "" {
+		base = final
+	}
+	res.Canonical = canonical(base, body)
+	return res
+}
@@ -0,0 +1,355 @@
+// Package checker tells whether bookmarked links still work, checking many
+// of them at once without hammering any single host.
+package checker
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/dav-m85/xbellum/metrics"
+	"github.com/dav-m85/xbellum/urlnorm"
+	"go.uber.org/zap"
+)
+
+// Status classifies the outcome of a check.
+type Status string
+
+const (
+	OK          Status = "ok"
+	Redirected  Status = "redirected"
+	ClientError Status = "4xx"
+	ServerError Status = "5xx"
+	DNSFailure  Status = "dns"
+	TLSError    Status = "tls"
+	Timeout     Status = "timeout"
+	// Failed is any other error, like a refused connection or too many
+	// redirects.
+	Failed Status = "error"
+	// SoftNotFound pages are missing although served with a 2xx status,
+	// as told by rules or the probe.
+	SoftNotFound Status = "soft404"
+	// Parked domains were left to a registrar, showing ads or selling the
+	// domain.
+	Parked Status = "parked"
+	// Skipped links aren't http or https.
+	Skipped Status = "skipped"
+)
+
+var checks = metrics.Default.NewCounter("xbellum_link_checks_total", "Checked links by result.", "result")
+
+// Result is the outcome of checking a URL.
+type Result struct {
+	URL    string
+	Status Status
+	// Code is the HTTP status of the last response, 0 when there was none.
+	Code int
+	// FinalURL is where redirects led, empty when there were none.
+	FinalURL string
+	// Permanent tells every redirect to FinalURL was permanent, the link
+	// being safe to replace.
+	Permanent bool
+	// Canonical is the <link rel=canonical> of the page, only looked for by
+	// Canonicals.
+	Canonical string
+	Err       error
+	Attempts  int
+	Duration  time.Duration
+}
+
+// Dead tells if the link is gone for good, as opposed to failing for a
+// while or refusing robots.
+func (r Result) Dead() bool {
+	switch r.Status {
+	case DNSFailure:
+		return true
+	case ClientError:
+		return r.Code == http.StatusNotFound || r.Code == http.StatusGone
+	}
+	return false
+}
+
+// Checker checks links, Concurrency at a time and at most PerHost at a time
+// on any host.
+type Checker struct {
+	Client      *http.Client
+	Timeout     time.Duration
+	Concurrency int
+	PerHost     int
+	// Retries is how many times timeouts, server and network errors are
+	// tried again, waiting Backoff then twice as long each time.
+	Retries      int
+	Backoff      time.Duration
+	MaxRedirects int
+	UserAgent    string
+	// Probe compares pages with a random sibling path, telling missing
+	// pages of servers answering 200 for anything.
+	Probe bool
+	// Parked tells pages of parked domains.
+	Parked bool
+	// URLs tells links leading to the same page, which are checked once and
+	// don't count as redirected to one another. Nil compares links as they
+	// are.
+	URLs *urlnorm.Normalizer
+
+	rules []rule
+
+	mu    sync.Mutex
+	hosts map[string]chan struct{}
+	// probes caches what directories answer for random paths, by URL.
+	probes map[string]probed
+}
+
+func New() *Checker {
+	c := &Checker{
+		Timeout:      10 * time.Second,
+		Concurrency:  8,
+		PerHost:      2,
+		Retries:      1,
+		Backoff:      time.Second,
+		MaxRedirects: 10,
+		UserAgent:    "xbellum",
+		hosts:        make(map[string]chan struct{}),
+		probes:       make(map[string]probed),
+	}
+	c.Client = &http.Client{CheckRedirect: c.checkRedirect}
+	return c
+}
+
+type ctxKey int
+
+const redirectsKey ctxKey = 0
+
+// redirects tells if a request went through temporary redirects.
+type redirects struct {
+	temporary bool
+}
+
+func (c *Checker) checkRedirect(req *http.Request, via []*http.Request) error {
+	if r, ok := req.Context().Value(redirectsKey).(*redirects); ok && req.Response != nil {
+		switch req.Response.StatusCode {
+		case http.StatusMovedPermanently, http.StatusPermanentRedirect:
+		default:
+			r.temporary = true
+		}
+	}
+	if len(via) >= c.MaxRedirects {
+		return fmt.Errorf("stopped after %d redirects", len(via))
+	}
+	return nil
+}
+
+// CheckAll checks urls, returning results in the same order.
+func (c *Checker) CheckAll(ctx context.Context, urls []string) []Result {
+	// Sites change between runs
+	c.mu.Lock()
+	c.probes = make(map[string]probed)
+	c.mu.Unlock()
+	return c.all(ctx, urls, c.try)
+}
+
+// all checks urls with try, at most Concurrency at a time, returning results
+// in the same order.
+func (c *Checker) all(ctx context.Context, urls []string, try func(context.Context, *url.URL) Result) []Result {
+	// Links to the same page are checked once, see URLs
+	first := make(map[string]int)
+	var todo []int
+	for i, u := range urls {
+		if _, ok := first[c.URLs.Key(u)]; !ok {
+			first[c.URLs.Key(u)] = i
+			todo = append(todo, i)
+		}
+	}
+
+	results := make([]Result, len(urls))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < c.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				results[j] = c.check(ctx, urls[j], try)
+			}
+		}()
+	}
+	for _, i := range todo {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	for i, u := range urls {
+		if j := first[c.URLs.Key(u)]; j != i {
+			results[i] = results[j]
+			results[i].URL = u
+		}
+	}
+	return results
+}
+
+// Check checks a single URL, retrying when it may work later.
+func (c *Checker) Check(ctx context.Context, rawURL string) Result {
+	return c.check(ctx, rawURL, c.try)
+}
+
+// check runs try on rawURL, retrying when it may work later.
+func (c *Checker) check(ctx context.Context, rawURL string, try func(context.Context, *url.URL) Result) Result {
+	start := time.Now()
+	res := Result{URL: rawURL}
+	u, err := url.Parse(rawURL)
+	if err == nil && u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "" {
+		res.Status = Skipped
+	} else if err != nil || u.Host == "" {
+		res.Status, res.Err = Failed, fmt.Errorf("invalid URL %q", rawURL)
+	} else {
+		release := c.acquire(u.Host)
+		backoff := c.Backoff
+		for attempt := 1; ; attempt++ {
+			res = try(ctx, u)
+			res.Attempts = attempt
+			if attempt > c.Retries || !retryable(res) || !sleep(ctx, backoff) {
+				break
+			}
+			backoff *= 2
+		}
+		release()
+	}
+	res.Duration = time.Since(start)
+	checks.Inc(string(res.Status))
+	zap.L().Debug("link checked", zap.String("url", rawURL), zap.String("status", string(res.Status)),
+		zap.Int("code", res.Code), zap.Int("attempts", res.Attempts), zap.Error(res.Err))
+	return res
+}
+
+// acquire waits for a slot on host, returning the function releasing it.
+func (c *Checker) acquire(host string) func() {
+	c.mu.Lock()
+	sem, ok := c.hosts[host]
+	if !ok {
+		sem = make(chan struct{}, c.PerHost)
+		c.hosts[host] = sem
+	}
+	c.mu.Unlock()
+	sem <- struct{}{}
+	return func() { <-sem }
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func retryable(r Result) bool {
+	switch r.Status {
+	case Timeout, ServerError, Failed:
+		return true
+	case ClientError:
+		return r.Code == http.StatusTooManyRequests
+	}
+	return false
+}
+
+// try asks for headers first, then the page as some servers don't answer
+// HEAD properly, or when it must be looked into for soft 404s.
+func (c *Checker) try(ctx context.Context, u *url.URL) Result {
+	rawURL := u.String()
+	rules := c.rulesFor(u)
+	inspect := len(rules) > 0 || c.Probe || c.Parked
+	res, _ := c.request(ctx, http.MethodHead, rawURL, false)
+	if res.Err != nil || res.Code < 400 && !inspect {
+		return res
+	}
+	res, body := c.request(ctx, http.MethodGet, rawURL, inspect)
+	if inspect && res.Err == nil && res.Code < 300 {
+		c.soft(ctx, u, &res, body, rules)
+	}
+	return res
+}
+
+// maxBody is how much of a page is looked into.
+const maxBody = 256 << 10
+
+// request returns the outcome of a request, and the start of the page when
+// keepBody is set.
+func (c *Checker) request(ctx context.Context, method, rawURL string, keepBody bool) (Result, []byte) {
+	res := Result{URL: rawURL}
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+	redir := &redirects{}
+	ctx = context.WithValue(ctx, redirectsKey, redir)
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		res.Status, res.Err = Failed, err
+		return res, nil
+	}
+	req.Header.Set("User-Agent", c.UserAgent)
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		res.Status, res.Err = classify(err), err
+		return res, nil
+	}
+	var body []byte
+	if keepBody {
+		body, _ = ioutil.ReadAll(io.LimitReader(resp.Body, maxBody))
+	}
+	// Let the connection be reused, without downloading whole pages
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+
+	res.Code = resp.StatusCode
+	// Redirects to another variant of the link, like /a to /a/, lead to the
+	// same page
+	if final := resp.Request.URL.String(); c.URLs.Key(final) != c.URLs.Key(rawURL) {
+		res.FinalURL = final
+		res.Permanent = !redir.temporary
+	}
+	switch {
+	case res.Code >= 500:
+		res.Status = ServerError
+	case res.Code >= 400:
+		res.Status = ClientError
+	case res.FinalURL != "":
+		res.Status = Redirected
+	default:
+		res.Status = OK
+	}
+	return res, body
+}
+
+func classify(err error) Status {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &dnsErr):
+		return DNSFailure
+	case isTLS(err):
+		return TLSError
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return Timeout
+	}
+	return Failed
+}
+
+func isTLS(err error) bool {
+	var unknown x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	var header tls.RecordHeaderError
+	return errors.As(err, &unknown) || errors.As(err, &hostname) ||
+		errors.As(err, &invalid) || errors.As(err, &header)
+}
@@ -0,0 +1,232 @@
+package checker
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dav-m85/xbellum/urlnorm"
+	"github.com/matryer/is"
+)
+
+func TestCheck(t *testing.T) {
+	is := is.New(t)
+
+	var flaky int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/ok", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/found", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/moved", http.StatusFound)
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	mux.HandleFunc("/gone", http.NotFound)
+	mux.HandleFunc("/broken", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	mux.HandleFunc("/flaky", func(w http.ResponseWriter, r *http.Request) {
+		// Fails both HEAD and GET of the first attempt
+		if atomic.AddInt32(&flaky, 1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	mux.HandleFunc("/nohead", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	tlsSrv := httptest.NewUnstartedServer(mux)
+	tlsSrv.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	tlsSrv.StartTLS()
+	defer tlsSrv.Close()
+
+	c := New()
+	c.Timeout = 5 * time.Second
+	c.Backoff = time.Millisecond
+	c.Retries = 1
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		url      string
+		status   Status
+		code     int
+		attempts int
+	}{
+		{srv.URL + "/ok", OK, 200, 1},
+		{srv.URL + "/moved", Redirected, 200, 1},
+		{srv.URL + "/loop", Failed, 0, 2},
+		{srv.URL + "/gone", ClientError, 404, 1},
+		{srv.URL + "/broken", ServerError, 500, 2},
+		{srv.URL + "/flaky", OK, 200, 2},
+		{srv.URL + "/nohead", OK, 200, 1},
+		{tlsSrv.URL + "/ok", TLSError, 0, 1},
+		{"javascript:void(0)", Skipped, 0, 0},
+		{"not a url", Failed, 0, 0},
+	} {
+		r := c.Check(ctx, tc.url)
+		is.Equal(r.Status, tc.status)     // status
+		is.Equal(r.Code, tc.code)         // code
+		is.Equal(r.Attempts, tc.attempts) // attempts
+	}
+
+	r := c.Check(ctx, srv.URL+"/moved")
+	is.Equal(r.FinalURL, srv.URL+"/ok")
+	is.True(r.Permanent)
+	r = c.Check(ctx, srv.URL+"/found")
+	is.Equal(r.FinalURL, srv.URL+"/ok")
+	is.True(!r.Permanent)
+	is.True(!r.Dead())
+	is.True(c.Check(ctx, srv.URL+"/gone").Dead())
+
+	// Only this one is given too little time
+	short := New()
+	short.Timeout = 50 * time.Millisecond
+	short.Backoff = time.Millisecond
+	r = short.Check(ctx, srv.URL+"/slow")
+	is.Equal(r.Status, Timeout)
+	is.Equal(r.Attempts, 2)
+
+	// Names are resolved by a stub rather than the network
+	dns := New()
+	dns.Client.Transport = &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return nil, &net.OpError{Op: "dial", Net: network, Err: &net.DNSError{Err: "no such host", Name: "nowhere.invalid", IsNotFound: true}}
+		},
+	}
+	r = dns.Check(ctx, "http://nowhere.invalid/")
+	is.Equal(r.Status, DNSFailure)
+	is.Equal(r.Attempts, 1)
+	is.True(r.Dead())
+}
+
+func TestPerHost(t *testing.T) {
+	is := is.New(t)
+
+	var mu sync.Mutex
+	running, max := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		running++
+		if running > max {
+			max = running
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	c := New()
+	c.Concurrency = 8
+	c.PerHost = 2
+	var urls []string
+	for i := 0; i < 10; i++ {
+		urls = append(urls, srv.URL+"/"+string(rune('a'+i)))
+	}
+	results := c.CheckAll(context.Background(), urls)
+	is.Equal(len(results), 10)
+	for i, r := range results {
+		is.Equal(r.URL, urls[i])
+		is.Equal(r.Status, OK)
+	}
+	is.True(max <= 2)
+}
+
+func TestCheckAllSamePage(t *testing.T) {
+	is := is.New(t)
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+	}))
+	defer srv.Close()
+
+	urls := []string{srv.URL + "/a", srv.URL + "/a/?utm_source=x", srv.URL + "/a#intro", srv.URL + "/b"}
+	c := New()
+	c.URLs = urlnorm.New()
+	results := c.CheckAll(context.Background(), urls)
+	is.Equal(atomic.LoadInt32(&hits), int32(2)) // /a once, /b once
+	for i, r := range results {
+		is.Equal(r.URL, urls[i]) // results keep their link
+		is.Equal(r.Status, OK)
+	}
+}
+
+func TestCheckAllVariants(t *testing.T) {
+	is := is.New(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/a", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/a/", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/a/", func(w http.ResponseWriter, r *http.Request) {})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	// Going from one variant to another isn't a redirect
+	c := New()
+	c.URLs = urlnorm.New()
+	results := c.CheckAll(context.Background(), []string{srv.URL + "/a", srv.URL + "/a/"})
+	for _, r := range results {
+		is.Equal(r.Status, OK)
+		is.Equal(r.FinalURL, "")
+	}
+	// Unless links are compared as they are
+	r := New().Check(context.Background(), srv.URL+"/a")
+	is.Equal(r.Status, Redirected)
+	is.Equal(r.FinalURL, srv.URL+"/a/")
+}
+
+func TestCanonicals(t *testing.T) {
+	is := is.New(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/amp/story", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><head><LINK href='/story?a=1&amp;b=2' rel="canonical"></head></html>`))
+	})
+	mux.HandleFunc("/old", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/story", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/story", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<link rel="stylesheet" href="/s.css"><link rel=canonical href=/story>`))
+	})
+	mux.HandleFunc("/gone", http.NotFound)
+	var busy int32
+	mux.HandleFunc("/busy", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&busy, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`<link rel="canonical" href="/story">`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	c := New()
+	c.Backoff = time.Millisecond
+	found := c.Canonicals(context.Background(), []string{
+		srv.URL + "/amp/story", srv.URL + "/old", srv.URL + "/story", srv.URL + "/gone", "place:x",
+		srv.URL + "/busy", srv.URL + "/busy",
+	})
+	is.Equal(found, map[string]string{
+		srv.URL + "/amp/story": srv.URL + "/story?a=1&b=2",
+		srv.URL + "/old":       srv.URL + "/story",
+		srv.URL + "/busy":      srv.URL + "/story",
+	})
+	is.Equal(atomic.LoadInt32(&busy), int32(2)) // retried, and fetched once
+}
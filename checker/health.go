@@ -0,0 +1,189 @@
+package checker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HealthFile is the name of the link health database, next to revisions.
+const HealthFile = "links.json"
+
+// DefaultDeadAfter is how many failed runs in a row make a link dead.
+const DefaultDeadAfter = 3
+
+// historyLen is how many runs are remembered per link.
+const historyLen = 10
+
+// Link states, as told by Health.State.
+const (
+	StateOK         = "ok"
+	StateRedirected = "redirected"
+	StateFlaky      = "flaky"
+	StateDead       = "dead"
+	StateSkipped    = "skipped"
+)
+
+// Run is the outcome of a link in one check run.
+type Run struct {
+	Time   time.Time `json:"time"`
+	Status Status    `json:"status"`
+	Code   int       `json:"code,omitempty"`
+}
+
+// Health is what check runs told about a link.
+type Health struct {
+	Href     string `json:"href"`
+	Status   Status `json:"status"`
+	Code     int    `json:"code,omitempty"`
+	FinalURL string `json:"final_url,omitempty"`
+	// Permanent tells redirects to FinalURL were permanent.
+	Permanent bool      `json:"permanent,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Checked   time.Time `json:"checked"`
+	LastOK    time.Time `json:"last_ok,omitempty"`
+	// Failures counts runs failed in a row, up to the last one.
+	Failures int `json:"failures"`
+	// Gone counts runs in a row telling the page is gone, see Gone.
+	Gone int `json:"gone"`
+	// History holds the last runs, oldest first.
+	History []Run `json:"history"`
+}
+
+// Working tells if a status means the link can be followed.
+func Working(s Status) bool {
+	return s == OK || s == Redirected || s == Skipped
+}
+
+// Gone tells if a failure means the page no longer exists, as opposed to a
+// site refusing robots or being down for a while.
+func Gone(s Status, code int) bool {
+	return Result{Status: s, Code: code}.Dead() || s == SoftNotFound || s == Parked
+}
+
+// State tells if the link is dead, having been gone deadAfter runs in a row,
+// flaky when it failed lately, redirected or ok.
+func (h Health) State(deadAfter int) string {
+	switch {
+	case h.Status == Skipped:
+		return StateSkipped
+	case h.Gone >= deadAfter:
+		return StateDead
+	case h.Failures > 0:
+		return StateFlaky
+	}
+	for _, r := range h.History {
+		if !Working(r.Status) {
+			return StateFlaky
+		}
+	}
+	if h.Status == Redirected {
+		return StateRedirected
+	}
+	return StateOK
+}
+
+// HealthDB keeps the health of links across runs, in a JSON file.
+type HealthDB struct {
+	fn string
+
+	mu    sync.Mutex
+	links map[string]*Health
+}
+
+// OpenHealth reads the database in fn, empty when fn doesn't exist yet.
+func OpenHealth(fn string) (*HealthDB, error) {
+	db := &HealthDB{fn: fn, links: make(map[string]*Health)}
+	buf, err := ioutil.ReadFile(fn)
+	if os.IsNotExist(err) {
+		return db, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var links []*Health
+	if err := json.Unmarshal(buf, &links); err != nil {
+		return nil, err
+	}
+	for _, h := range links {
+		db.links[h.Href] = h
+	}
+	return db, nil
+}
+
+// Record adds results of a run made at t and saves the database.
+func (db *HealthDB) Record(results []Result, t time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, r := range results {
+		h, ok := db.links[r.URL]
+		if !ok {
+			h = &Health{Href: r.URL}
+			db.links[r.URL] = h
+		}
+		h.Status, h.Code, h.FinalURL, h.Permanent, h.Checked = r.Status, r.Code, r.FinalURL, r.Permanent, t
+		h.Error = ""
+		if r.Err != nil {
+			h.Error = r.Err.Error()
+		}
+		if Working(r.Status) {
+			h.Failures = 0
+			h.LastOK = t
+		} else {
+			h.Failures++
+		}
+		if Gone(r.Status, r.Code) {
+			h.Gone++
+		} else {
+			h.Gone = 0
+		}
+		h.History = append(h.History, Run{Time: t, Status: r.Status, Code: r.Code})
+		if len(h.History) > historyLen {
+			h.History = h.History[len(h.History)-historyLen:]
+		}
+	}
+	return db.save()
+}
+
+func (db *HealthDB) save() error {
+	buf, err := json.MarshalIndent(db.all(), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(db.fn), "."+filepath.Base(db.fn)+".tmp")
+	if err := ioutil.WriteFile(tmp, buf, 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, db.fn)
+}
+
+// Get returns the health of href, if it was ever checked.
+func (db *HealthDB) Get(href string) (Health, bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	h, ok := db.links[href]
+	if !ok {
+		return Health{}, false
+	}
+	return *h, true
+}
+
+// All returns the health of every checked link, by href.
+func (db *HealthDB) All() []Health {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.all()
+}
+
+func (db *HealthDB) all() []Health {
+	all := make([]Health, 0, len(db.links))
+	for _, h := range db.links {
+		all = append(all, *h)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Href < all[j].Href })
+	return all
+}
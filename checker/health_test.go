@@ -0,0 +1,68 @@
+package checker
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestHealth(t *testing.T) {
+	is := is.New(t)
+
+	fn := filepath.Join(t.TempDir(), HealthFile)
+	db, err := OpenHealth(fn)
+	is.NoErr(err)
+	is.Equal(len(db.All()), 0)
+
+	day := func(n int) time.Time { return time.Date(2021, 6, n, 0, 0, 0, 0, time.UTC) }
+	const a, b, c, d = "http://a/", "http://b/", "http://c/", "http://d/"
+	is.NoErr(db.Record([]Result{
+		{URL: a, Status: OK, Code: 200},
+		{URL: b, Status: ClientError, Code: 404},
+		{URL: c, Status: Redirected, Code: 200, FinalURL: "http://c2/"},
+		{URL: d, Status: ClientError, Code: 403},
+	}, day(1)))
+	is.NoErr(db.Record([]Result{
+		{URL: a, Status: Timeout, Err: errors.New("too slow")},
+		{URL: b, Status: ClientError, Code: 404},
+		{URL: d, Status: ServerError, Code: 503},
+	}, day(2)))
+
+	h, ok := db.Get(b)
+	is.True(ok)
+	is.Equal(h.Failures, 2)
+	is.Equal(h.State(3), StateFlaky)
+	is.True(h.LastOK.IsZero())
+	is.NoErr(db.Record([]Result{{URL: b, Status: DNSFailure}, {URL: d, Status: TLSError}}, day(3)))
+
+	// Reopened from disk
+	db, err = OpenHealth(fn)
+	is.NoErr(err)
+	states := make(map[string]string)
+	for _, h := range db.All() {
+		states[h.Href] = h.State(3)
+	}
+	// Forbidden, down or misconfigured sites are never dead
+	is.Equal(states, map[string]string{a: StateFlaky, b: StateDead, c: StateRedirected, d: StateFlaky})
+	h, _ = db.Get(d)
+	is.Equal(h.Failures, 3)
+
+	h, _ = db.Get(a)
+	is.Equal(h.Error, "too slow")
+	is.Equal(h.LastOK, day(1))
+	is.Equal(len(h.History), 2)
+
+	// Recovering resets failures, the history still tells it was flaky
+	is.NoErr(db.Record([]Result{{URL: a, Status: OK, Code: 200}}, day(4)))
+	h, _ = db.Get(a)
+	is.Equal(h.Failures, 0)
+	is.Equal(h.State(3), StateFlaky)
+
+	buf, err := ioutil.ReadFile(fn)
+	is.NoErr(err)
+	is.True(len(buf) > 0)
+}
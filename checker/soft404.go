@@ -0,0 +1,228 @@
+package checker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Rule tells pages of some links are missing although served with a 2xx
+// status. Empty fields are ignored, a rule without Domain nor URL applies to
+// every link.
+type Rule struct {
+	// Domain matches a host and its subdomains, like example.com.
+	Domain string
+	// URL is a regular expression matched against links.
+	URL string
+	// Body and Title are regular expressions telling a missing page.
+	Body  string
+	Title string
+	// Homepage tells links redirected to the site root are missing.
+	Homepage bool
+}
+
+type rule struct {
+	domain           string
+	url, body, title *regexp.Regexp
+	homepage         bool
+}
+
+func compile(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return regexp.Compile(expr)
+}
+
+// AddRule adds a soft 404 rule, failing on invalid regular expressions.
+func (c *Checker) AddRule(r Rule) error {
+	if r.Body == "" && r.Title == "" && !r.Homepage {
+		return errors.New("rule needs body, title or homepage")
+	}
+	cr := rule{domain: strings.ToLower(strings.TrimPrefix(r.Domain, ".")), homepage: r.Homepage}
+	var err error
+	if cr.url, err = compile(r.URL); err != nil {
+		return fmt.Errorf("url: %w", err)
+	}
+	if cr.body, err = compile(r.Body); err != nil {
+		return fmt.Errorf("body: %w", err)
+	}
+	if cr.title, err = compile(r.Title); err != nil {
+		return fmt.Errorf("title: %w", err)
+	}
+	c.rules = append(c.rules, cr)
+	return nil
+}
+
+func (r rule) applies(u *url.URL) bool {
+	host := strings.ToLower(u.Hostname())
+	if r.domain != "" && host != r.domain && !strings.HasSuffix(host, "."+r.domain) {
+		return false
+	}
+	return r.url == nil || r.url.MatchString(u.String())
+}
+
+func (c *Checker) rulesFor(u *url.URL) []rule {
+	var rules []rule
+	for _, r := range c.rules {
+		if r.applies(u) {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+// parked are signatures of parked domain pages, put up by registrars and
+// domain resellers.
+var parked = regexp.MustCompile(`(?i)this domain (name )?(is|may be) for sale|buy this domain|domain is parked|` +
+	`parked free|sedoparking|parkingcrew|bodis\.com|afternic|hugedomains|dan\.com/buy-domain`)
+
+var titleReg = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+func title(body []byte) string {
+	m := titleReg.FindSubmatch(body)
+	if m == nil {
+		return ""
+	}
+	return strings.TrimSpace(string(m[1]))
+}
+
+// redirectedHome tells if a link to a page was redirected to its site root.
+func redirectedHome(rawURL string, res Result) bool {
+	if res.FinalURL == "" {
+		return false
+	}
+	from, err1 := url.Parse(rawURL)
+	to, err2 := url.Parse(res.FinalURL)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	return strings.Trim(from.Path, "/") != "" && strings.Trim(to.Path, "/") == "" && to.RawQuery == ""
+}
+
+// soft looks into a working page for signs it is missing, turning res into
+// SoftNotFound or Parked.
+func (c *Checker) soft(ctx context.Context, u *url.URL, res *Result, body []byte, rules []rule) {
+	fail := func(s Status, format string, a ...interface{}) {
+		res.Status, res.Err = s, fmt.Errorf(format, a...)
+	}
+	for _, r := range rules {
+		switch {
+		case r.homepage && redirectedHome(res.URL, *res):
+			fail(SoftNotFound, "redirected to homepage")
+		case r.body != nil && r.body.Match(body):
+			fail(SoftNotFound, "page matches %q", r.body)
+		case r.title != nil && r.title.MatchString(title(body)):
+			fail(SoftNotFound, "title matches %q", r.title)
+		default:
+			continue
+		}
+		return
+	}
+	if c.Parked && parked.Match(body) {
+		fail(Parked, "parked domain")
+		return
+	}
+	if !c.Probe {
+		return
+	}
+	if redirectedHome(res.URL, *res) {
+		fail(SoftNotFound, "redirected to homepage")
+		return
+	}
+	// A site root has no sibling to compare with
+	if strings.Trim(u.Path, "/") == "" {
+		return
+	}
+	p, ok := c.probe(ctx, u)
+	if !ok {
+		return
+	}
+	if res.FinalURL != "" && res.FinalURL == p.finalURL {
+		fail(SoftNotFound, "redirected like a missing page")
+	} else if similar(words(body, u.Path), p.words) {
+		fail(SoftNotFound, "same as a missing page")
+	}
+}
+
+// probed is what a random sibling path answered.
+type probed struct {
+	finalURL string
+	words    map[string]bool
+}
+
+// probe asks for a random path next to u, remembering what the server
+// answered for the directory. ok is false when it answered as it should,
+// with an error status.
+func (c *Checker) probe(ctx context.Context, u *url.URL) (probed, bool) {
+	sibling := *u
+	sibling.RawQuery, sibling.Fragment = "", ""
+	sibling.Path = path.Join(path.Dir(strings.TrimSuffix(u.Path, "/")), "xbellum-probe-"+randomName())
+	key := u.Scheme + "://" + u.Host + path.Dir(sibling.Path)
+
+	c.mu.Lock()
+	p, ok := c.probes[key]
+	c.mu.Unlock()
+	if ok {
+		return p, p.words != nil
+	}
+
+	res, body := c.request(ctx, http.MethodGet, sibling.String(), true)
+	if res.Err == nil && res.Code < 300 {
+		p = probed{finalURL: res.FinalURL, words: words(body, sibling.Path)}
+	}
+	c.mu.Lock()
+	c.probes[key] = p
+	c.mu.Unlock()
+	return p, p.words != nil
+}
+
+var tagReg = regexp.MustCompile(`(?s)<script.*?</script>|<style.*?</style>|<[^>]*>`)
+
+// words returns the set of words of a page, without markup nor the path it
+// was asked with, which missing pages often quote.
+func words(body []byte, p string) map[string]bool {
+	text := string(body)
+	if p != "" {
+		text = strings.ReplaceAll(text, p, " ")
+		// Short names would remove common words
+		if base := path.Base(p); len(base) >= 4 {
+			text = strings.ReplaceAll(text, base, " ")
+		}
+	}
+	set := make(map[string]bool)
+	for _, w := range strings.Fields(strings.ToLower(tagReg.ReplaceAllString(text, " "))) {
+		set[w] = true
+	}
+	return set
+}
+
+// similar tells if two pages share nearly all of their words, a missing page
+// usually differing from another only by the path it quotes.
+func similar(a, b map[string]bool) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	common := 0
+	for w := range a {
+		if b[w] {
+			common++
+		}
+	}
+	return float64(common)/float64(len(a)+len(b)-common) >= 0.9
+}
+
+func randomName() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,68 @@
+package checker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestSoft404(t *testing.T) {
+	is := is.New(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, "<title>Home</title> welcome to our site")
+		case "/posts/real":
+			fmt.Fprint(w, "<title>A real post</title> with many words about something else entirely")
+		case "/blog/gone":
+			fmt.Fprint(w, "<title>Blog</title> Post Not Found")
+		case "/wiki/gone":
+			fmt.Fprint(w, "<title>Page missing - Wiki</title> try searching")
+		case "/old":
+			http.Redirect(w, r, "/", http.StatusFound)
+		case "/sale":
+			fmt.Fprint(w, "<h1>This domain is for sale!</h1>")
+		default:
+			// Missing pages are served with 200, quoting their path
+			fmt.Fprintf(w, "<title>Oops</title> <p>Sorry, %s could not be found on this server, go back home</p>", r.URL.Path)
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	c := New()
+	is.True(c.AddRule(Rule{URL: "^http://nowhere/"}) != nil)
+	is.True(c.AddRule(Rule{Body: "("}) != nil)
+	is.NoErr(c.AddRule(Rule{Domain: "127.0.0.1", URL: "/blog/", Body: "Post Not Found"}))
+	is.NoErr(c.AddRule(Rule{Domain: "127.0.0.1", Title: "(?i)missing"}))
+	ctx := context.Background()
+
+	status := func(p string) Status { return c.Check(ctx, srv.URL+p).Status }
+	is.Equal(status("/blog/gone"), SoftNotFound)
+	is.Equal(status("/wiki/gone"), SoftNotFound)
+	is.Equal(status("/posts/real"), OK)
+	// Without the probe, missing pages and parked domains go unnoticed
+	is.Equal(status("/posts/fake"), OK)
+	is.Equal(status("/old"), Redirected)
+	is.Equal(status("/sale"), OK)
+
+	c.Probe, c.Parked = true, true
+	is.Equal(status("/posts/real"), OK)
+	is.Equal(status("/posts/fake"), SoftNotFound)
+	is.Equal(status("/old"), SoftNotFound)
+	is.Equal(status("/sale"), Parked)
+	is.Equal(status("/"), OK)
+
+	// Rules for other domains don't apply
+	c = New()
+	is.NoErr(c.AddRule(Rule{Domain: "example.com", Body: "Post Not Found"}))
+	is.Equal(status("/blog/gone"), OK)
+	is.NoErr(c.AddRule(Rule{Homepage: true}))
+	is.Equal(status("/old"), SoftNotFound)
+}
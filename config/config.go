@@ -0,0 +1,522 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dav-m85/xbellum/dedup"
+	"github.com/dav-m85/xbellum/jobs"
+	"github.com/dav-m85/xbellum/proxy"
+	"github.com/dav-m85/xbellum/urlnorm"
+	dav "github.com/dav-m85/xbellum/webdav"
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the server configuration, read from a YAML file and overridden
+// by environment variables.
+type Config struct {
+	Listen string `yaml:"listen"`
+	// ShutdownTimeout is how long requests in flight get to finish when
+	// stopping.
+	ShutdownTimeout Duration `yaml:"shutdown_timeout"`
+	Root            string   `yaml:"root"`
+	// AdminSocket is where the server takes commands of the command line,
+	// admin.sock in the data root by default.
+	AdminSocket string `yaml:"admin_socket"`
+	// Secret is the shared password used when there are no users.
+	Secret string `yaml:"secret"`
+	// UsersFile points to a users file, whose users add to Users.
+	UsersFile string          `yaml:"users_file"`
+	Users     map[string]User `yaml:"users"`
+	// Cors lists origins allowed to make cross-origin requests, or "*".
+	Cors       []string   `yaml:"cors"`
+	LoginLimit LoginLimit `yaml:"login_limit"`
+	Sessions   Sessions   `yaml:"sessions"`
+	TLS        TLS        `yaml:"tls"`
+	Proxy      Proxy      `yaml:"proxy"`
+	Metrics    Metrics    `yaml:"metrics"`
+	Guards     Guards     `yaml:"guards"`
+	Retention  Retention  `yaml:"retention"`
+	URLs       URLs       `yaml:"urls"`
+	Dedup      Dedup      `yaml:"dedup"`
+	LinkCheck  LinkCheck  `yaml:"link_check"`
+	Jobs       Jobs       `yaml:"jobs"`
+	Log        Log        `yaml:"log"`
+}
+
+type User struct {
+	// Password is plain text or a hash-password result.
+	Password string `yaml:"password"`
+	// Role is read, write or admin, write by default.
+	Role string `yaml:"role"`
+	// Rules are path=access overrides, see webdav.ParseRule.
+	Rules []string `yaml:"rules"`
+}
+
+// LoginLimit locks out addresses and users failing to log in, see
+// auth.Limiter.
+type LoginLimit struct {
+	// Free is the number of failures before locking.
+	Free int `yaml:"free"`
+	// Lockout is the first lock duration, doubling up to MaxLockout.
+	Lockout    Duration `yaml:"lockout"`
+	MaxLockout Duration `yaml:"max_lockout"`
+}
+
+// Sessions are web UI logins.
+type Sessions struct {
+	MaxAge Duration `yaml:"max_age"`
+}
+
+type TLS struct {
+	// Cert and Key are PEM files, reloaded when they change.
+	Cert string `yaml:"cert"`
+	Key  string `yaml:"key"`
+	// RedirectHTTP is an address where plain HTTP is redirected to HTTPS.
+	RedirectHTTP string `yaml:"redirect_http"`
+	// HSTS is the Strict-Transport-Security max age, 0 to disable.
+	HSTS Duration `yaml:"hsts"`
+}
+
+// Proxy is a reverse proxy in front of the server, see proxy.Proxy.
+type Proxy struct {
+	// Trusted lists networks or addresses whose X-Forwarded-* headers are
+	// believed.
+	Trusted []string `yaml:"trusted"`
+	// UserHeader, like X-Forwarded-User, carries the username the proxy
+	// authenticated.
+	UserHeader string `yaml:"user_header"`
+}
+
+// Metrics configures the monitoring endpoints.
+type Metrics struct {
+	// Listen is an address serving /metrics, /healthz and /readyz without
+	// authentication, for internal networks. /metrics is otherwise for
+	// admins only.
+	Listen string `yaml:"listen"`
+}
+
+// Guards reject uploads removing too many bookmarks at once.
+type Guards struct {
+	MaxRemoved      int     `yaml:"max_removed"`
+	MaxRemovedRatio float64 `yaml:"max_removed_ratio"`
+}
+
+// Retention is used when pruning old revisions.
+type Retention struct {
+	Keep   int      `yaml:"keep"`
+	MaxAge Duration `yaml:"max_age"`
+}
+
+// URLs tells which differences between links are ignored when looking for
+// duplicates, comparing revisions, searching and checking, see
+// urlnorm.Normalizer.
+type URLs struct {
+	Scheme        bool `yaml:"scheme"`
+	WWW           bool `yaml:"www"`
+	TrailingSlash bool `yaml:"trailing_slash"`
+	DefaultPort   bool `yaml:"default_port"`
+	Fragment      bool `yaml:"fragment"`
+	SortQuery     bool `yaml:"sort_query"`
+	// Tracking are query parameters to ignore, a trailing * matching any
+	// suffix.
+	Tracking []string `yaml:"tracking"`
+}
+
+// Dedup tells which bookmark of duplicates survives, see dedup.Policy.
+type Dedup struct {
+	// Keep is first, oldest, newest, deepest or folder.
+	Keep   string `yaml:"keep"`
+	Folder string `yaml:"folder"`
+	Merge  bool   `yaml:"merge"`
+}
+
+type LinkCheck struct {
+	Timeout     Duration `yaml:"timeout"`
+	Concurrency int      `yaml:"concurrency"`
+	PerHost     int      `yaml:"per_host"`
+	Retries     int      `yaml:"retries"`
+	UserAgent   string   `yaml:"user_agent"`
+	// DeadAfter is how many runs in a row a link must fail to be dead.
+	DeadAfter int `yaml:"dead_after"`
+	// Probe compares pages with a random sibling path, to spot servers
+	// answering 200 for missing pages.
+	Probe bool `yaml:"probe"`
+	// Parked spots parked domains, for sale or showing ads.
+	Parked bool       `yaml:"parked"`
+	Rules  []SoftRule `yaml:"rules"`
+	Fix    Fix        `yaml:"fix"`
+}
+
+// Fix tells how check outcomes are written back into bookmarks.
+type Fix struct {
+	// Dead is prefix, to put Prefix in front of dead link titles, move, to
+	// move them into Folder, or empty to leave them be.
+	Dead   string `yaml:"dead"`
+	Prefix string `yaml:"prefix"`
+	Folder string `yaml:"folder"`
+	// Redirects replaces permanently redirected links.
+	Redirects bool `yaml:"redirects"`
+}
+
+// SoftRule tells missing pages served with a 2xx status, on links matching
+// Domain and URL.
+type SoftRule struct {
+	// Domain also matches subdomains.
+	Domain string `yaml:"domain"`
+	// URL, Body and Title are regular expressions.
+	URL   string `yaml:"url"`
+	Body  string `yaml:"body"`
+	Title string `yaml:"title"`
+	// Homepage tells links redirected to their site root are missing.
+	Homepage bool `yaml:"homepage"`
+}
+
+// Jobs are schedules of tasks the server runs by itself, as understood by
+// jobs.Parse. Tasks without a schedule only run on demand from /info/jobs.
+type Jobs struct {
+	Check  string `yaml:"check"`
+	Prune  string `yaml:"prune"`
+	Backup string `yaml:"backup"`
+	Report string `yaml:"report"`
+	// BackupDir receives archives of the data root, backups in the data root
+	// by default, keeping the BackupKeep latest.
+	BackupDir  string `yaml:"backup_dir"`
+	BackupKeep int    `yaml:"backup_keep"`
+}
+
+type Log struct {
+	// Level is debug, info, warn or error.
+	Level string `yaml:"level"`
+	// Format is console or json.
+	Format string `yaml:"format"`
+}
+
+// Duration is a time.Duration written like 10s or 720h.
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+// Default returns the configuration used when there is no file.
+func Default() *Config {
+	return &Config{
+		Listen:          ":8082",
+		ShutdownTimeout: Duration{30 * time.Second},
+		Root:            "./data",
+		LoginLimit: LoginLimit{
+			Free:       5,
+			Lockout:    Duration{time.Second},
+			MaxLockout: Duration{15 * time.Minute},
+		},
+		Sessions: Sessions{
+			MaxAge: Duration{7 * 24 * time.Hour},
+		},
+		URLs: URLs{
+			Scheme:        true,
+			WWW:           true,
+			TrailingSlash: true,
+			DefaultPort:   true,
+			Fragment:      true,
+			SortQuery:     true,
+			Tracking:      urlnorm.Tracking,
+		},
+		Dedup: Dedup{
+			Keep: dedup.KeepFirst,
+		},
+		LinkCheck: LinkCheck{
+			Timeout:     Duration{10 * time.Second},
+			Concurrency: 8,
+			PerHost:     2,
+			Retries:     1,
+			UserAgent:   "xbellum",
+			DeadAfter:   3,
+			Fix: Fix{
+				Prefix: "[dead] ",
+				Folder: "Dead links",
+			},
+		},
+		Jobs: Jobs{
+			BackupKeep: 7,
+		},
+		Log: Log{
+			Level:  "info",
+			Format: "console",
+		},
+	}
+}
+
+// Load reads the file fn over defaults, fn being optional, then applies
+// environment overrides and validates the result.
+func Load(fn string) (*Config, error) {
+	c := Default()
+	if fn != "" {
+		buf, err := ioutil.ReadFile(fn)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.UnmarshalStrict(buf, c); err != nil {
+			return nil, fmt.Errorf("%s: %s", fn, err)
+		}
+	}
+	c.applyEnv()
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// env lists the environment variables overriding a setting.
+var env = []struct {
+	name string
+	set  func(c *Config, v string)
+}{
+	{"LISTEN", func(c *Config, v string) { c.Listen = v }},
+	{"ROOT", func(c *Config, v string) { c.Root = v }},
+	{"SECRET", func(c *Config, v string) { c.Secret = v }},
+	{"USERS", func(c *Config, v string) { c.UsersFile = v }},
+	{"CORS", func(c *Config, v string) { c.Cors = list(v) }},
+	{"TLS_CERT", func(c *Config, v string) { c.TLS.Cert = v }},
+	{"TLS_KEY", func(c *Config, v string) { c.TLS.Key = v }},
+	{"TRUSTED_PROXIES", func(c *Config, v string) { c.Proxy.Trusted = list(v) }},
+	{"PROXY_USER_HEADER", func(c *Config, v string) { c.Proxy.UserHeader = v }},
+	{"LOG_LEVEL", func(c *Config, v string) { c.Log.Level = v }},
+	{"LOG_FORMAT", func(c *Config, v string) { c.Log.Format = v }},
+}
+
+// list splits a comma separated value, trimming items and dropping empty ones.
+func list(v string) []string {
+	var items []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			items = append(items, s)
+		}
+	}
+	return items
+}
+
+func (c *Config) applyEnv() {
+	for _, e := range env {
+		if v, ok := os.LookupEnv(e.name); ok && v != "" {
+			e.set(c, v)
+		}
+	}
+}
+
+// Error is a validation error on a given key, like users.alice.role.
+type Error struct {
+	Key string
+	Err error
+}
+
+func (e Error) Error() string {
+	return e.Key + ": " + e.Err.Error()
+}
+
+// Errors gathers all validation errors of a configuration.
+type Errors []Error
+
+func (e Errors) Error() string {
+	s := make([]string, len(e))
+	for i, err := range e {
+		s[i] = err.Error()
+	}
+	return strings.Join(s, "\n")
+}
+
+func (e *Errors) add(key string, format string, a ...interface{}) {
+	*e = append(*e, Error{Key: key, Err: fmt.Errorf(format, a...)})
+}
+
+// Validate checks every setting, returning Errors if any is wrong.
+func (c *Config) Validate() error {
+	var errs Errors
+
+	if c.Listen == "" {
+		errs.add("listen", "must not be empty")
+	}
+	if c.Metrics.Listen != "" && c.Metrics.Listen == c.Listen {
+		errs.add("metrics.listen", "must differ from listen")
+	}
+	if c.Root == "" {
+		errs.add("root", "must not be empty")
+	}
+	if c.ShutdownTimeout.Duration <= 0 {
+		errs.add("shutdown_timeout", "must be positive")
+	}
+
+	names := make([]string, 0, len(c.Users))
+	for n := range c.Users {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		u := c.Users[n]
+		key := "users." + n
+		if !dav.ValidUsername(n) {
+			errs.add(key, "invalid username, use letters, digits, dots, dashes and underscores")
+		}
+		if u.Password == "" {
+			errs.add(key+".password", "must not be empty")
+		}
+		if u.Role != "" {
+			if _, err := dav.ParseRole(u.Role); err != nil {
+				errs.add(key+".role", "%s", err)
+			}
+		}
+		for i, r := range u.Rules {
+			if _, err := dav.ParseRule(r); err != nil {
+				errs.add(fmt.Sprintf("%s.rules[%d]", key, i), "%s", err)
+			}
+		}
+	}
+	// The shared user is an admin, any password would do otherwise
+	if len(c.Users) == 0 && c.UsersFile == "" && c.Secret == "" {
+		errs.add("secret", "must not be empty when there are no users")
+	}
+
+	if c.LoginLimit.Free < 0 {
+		errs.add("login_limit.free", "must not be negative")
+	}
+	if c.LoginLimit.Lockout.Duration <= 0 {
+		errs.add("login_limit.lockout", "must be positive")
+	}
+	if c.LoginLimit.MaxLockout.Duration < c.LoginLimit.Lockout.Duration {
+		errs.add("login_limit.max_lockout", "must be at least lockout")
+	}
+
+	if c.Sessions.MaxAge.Duration <= 0 {
+		errs.add("sessions.max_age", "must be positive")
+	}
+
+	if (c.TLS.Cert == "") != (c.TLS.Key == "") {
+		errs.add("tls", "cert and key go together")
+	}
+	if c.TLS.Cert == "" && c.TLS.RedirectHTTP != "" {
+		errs.add("tls.redirect_http", "requires a cert and key")
+	}
+	if c.TLS.Cert == "" && c.TLS.HSTS.Duration != 0 {
+		errs.add("tls.hsts", "requires a cert and key")
+	}
+	if c.TLS.HSTS.Duration < 0 {
+		errs.add("tls.hsts", "must not be negative")
+	}
+
+	for i, t := range c.Proxy.Trusted {
+		if _, err := proxy.ParseCIDR(t); err != nil {
+			errs.add(fmt.Sprintf("proxy.trusted[%d]", i), "%s", err)
+		}
+	}
+	if c.Proxy.UserHeader != "" && len(c.Proxy.Trusted) == 0 {
+		errs.add("proxy.user_header", "requires trusted proxies")
+	}
+
+	if c.Guards.MaxRemoved < 0 {
+		errs.add("guards.max_removed", "must not be negative")
+	}
+	if c.Guards.MaxRemovedRatio < 0 || c.Guards.MaxRemovedRatio > 1 {
+		errs.add("guards.max_removed_ratio", "must be between 0 and 1")
+	}
+
+	if c.Retention.Keep < 0 {
+		errs.add("retention.keep", "must not be negative")
+	}
+	if c.Retention.MaxAge.Duration < 0 {
+		errs.add("retention.max_age", "must not be negative")
+	}
+
+	for i, t := range c.URLs.Tracking {
+		if strings.TrimSuffix(t, "*") == "" {
+			errs.add(fmt.Sprintf("urls.tracking[%d]", i), "must name a parameter")
+		}
+	}
+
+	if err := (dedup.Policy{Keep: c.Dedup.Keep, Folder: c.Dedup.Folder}).Validate(); err != nil {
+		errs.add("dedup", "%s", err)
+	}
+
+	if c.LinkCheck.Timeout.Duration <= 0 {
+		errs.add("link_check.timeout", "must be positive")
+	}
+	if c.LinkCheck.Concurrency < 1 {
+		errs.add("link_check.concurrency", "must be at least 1")
+	}
+	if c.LinkCheck.PerHost < 1 {
+		errs.add("link_check.per_host", "must be at least 1")
+	}
+	if c.LinkCheck.Retries < 0 {
+		errs.add("link_check.retries", "must not be negative")
+	}
+	if c.LinkCheck.DeadAfter < 1 {
+		errs.add("link_check.dead_after", "must be at least 1")
+	}
+	switch c.LinkCheck.Fix.Dead {
+	case "", "prefix", "move":
+	default:
+		errs.add("link_check.fix.dead", "must be prefix, move or empty")
+	}
+	if c.LinkCheck.Fix.Dead == "prefix" && strings.TrimSpace(c.LinkCheck.Fix.Prefix) == "" {
+		errs.add("link_check.fix.prefix", "must not be empty")
+	}
+	if c.LinkCheck.Fix.Dead == "move" && strings.TrimSpace(c.LinkCheck.Fix.Folder) == "" {
+		errs.add("link_check.fix.folder", "must not be empty")
+	}
+	for i, r := range c.LinkCheck.Rules {
+		key := fmt.Sprintf("link_check.rules[%d]", i)
+		if r.Body == "" && r.Title == "" && !r.Homepage {
+			errs.add(key, "needs body, title or homepage")
+		}
+		for _, f := range []struct{ name, expr string }{{"url", r.URL}, {"body", r.Body}, {"title", r.Title}} {
+			if _, err := regexp.Compile(f.expr); err != nil {
+				errs.add(key+"."+f.name, "invalid regular expression: %s", err)
+			}
+		}
+	}
+
+	for _, j := range []struct{ name, spec string }{
+		{"check", c.Jobs.Check}, {"prune", c.Jobs.Prune}, {"backup", c.Jobs.Backup}, {"report", c.Jobs.Report},
+	} {
+		if j.spec == "" {
+			continue
+		}
+		if _, err := jobs.Parse(j.spec); err != nil {
+			errs.add("jobs."+j.name, "%s", err)
+		}
+	}
+	if c.Jobs.BackupKeep < 1 {
+		errs.add("jobs.backup_keep", "must be at least 1")
+	}
+
+	switch c.Log.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		errs.add("log.level", "must be one of debug, info, warn, error")
+	}
+	switch c.Log.Format {
+	case "console", "json":
+	default:
+		errs.add("log.format", "must be console or json")
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
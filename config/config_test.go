@@ -0,0 +1,129 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func write(t *testing.T, content string) string {
+	fn := filepath.Join(t.TempDir(), "xbellum.yml")
+	if err := ioutil.WriteFile(fn, []byte(content), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return fn
+}
+
+func TestLoad(t *testing.T) {
+	is := is.New(t)
+
+	c, err := Load(write(t, `
+listen: ":9000"
+users:
+  alice:
+    password: "{bcrypt}xxx"
+    role: admin
+  tablet:
+    password: secret
+    role: read
+    rules: ["/kid.xbel=write"]
+guards:
+  max_removed_ratio: 0.5
+retention:
+  keep: 100
+  max_age: 720h
+link_check:
+  timeout: 3s
+`))
+	is.NoErr(err)
+	is.Equal(c.Listen, ":9000")
+	is.Equal(c.Root, "./data") // default
+	is.Equal(c.Users["tablet"].Rules, []string{"/kid.xbel=write"})
+	is.Equal(c.Retention.MaxAge.Duration, 720*time.Hour)
+	is.Equal(c.LinkCheck.Timeout.Duration, 3*time.Second)
+	is.Equal(c.LinkCheck.Concurrency, 8) // default
+
+	// No file at all is fine, given the shared secret
+	_, err = Load("")
+	is.Equal(err.Error(), "secret: must not be empty when there are no users")
+	os.Setenv("SECRET", "x")
+	defer os.Unsetenv("SECRET")
+	c, err = Load("")
+	is.NoErr(err)
+	is.Equal(c.Listen, ":8082")
+}
+
+func TestEnv(t *testing.T) {
+	is := is.New(t)
+
+	os.Setenv("LISTEN", ":7000")
+	os.Setenv("SECRET", "x")
+	os.Setenv("CORS", "https://a.example, https://b.example,")
+	os.Setenv("TRUSTED_PROXIES", "10.0.0.0/8 , 127.0.0.1")
+	defer os.Unsetenv("LISTEN")
+	defer os.Unsetenv("SECRET")
+	defer os.Unsetenv("CORS")
+	defer os.Unsetenv("TRUSTED_PROXIES")
+
+	c, err := Load(write(t, "listen: \":9000\"\n"))
+	is.NoErr(err)
+	is.Equal(c.Listen, ":7000")
+	is.Equal(c.Cors, []string{"https://a.example", "https://b.example"})
+	is.Equal(c.Proxy.Trusted, []string{"10.0.0.0/8", "127.0.0.1"})
+}
+
+func TestValidate(t *testing.T) {
+	is := is.New(t)
+
+	_, err := Load(write(t, `
+users:
+  alice:
+    role: owner
+    rules: ["/ok=read", "/nope"]
+tls:
+  cert: cert.pem
+guards:
+  max_removed_ratio: 2
+link_check:
+  rules:
+    - domain: example.com
+    - body: "("
+dedup:
+  keep: folder
+jobs:
+  check: "@daily"
+  prune: "0 25 * * *"
+log:
+  format: xml
+`))
+	errs, ok := err.(Errors)
+	is.True(ok)
+	var keys []string
+	for _, e := range errs {
+		keys = append(keys, e.Key)
+	}
+	is.Equal(keys, []string{
+		"users.alice.password",
+		"users.alice.role",
+		"users.alice.rules[1]",
+		"tls",
+		"guards.max_removed_ratio",
+		"dedup",
+		"link_check.rules[0]",
+		"link_check.rules[1].body",
+		"jobs.prune",
+		"log.format",
+	})
+
+	// Unknown keys and bad types are reported with their line
+	_, err = Load(write(t, "listen: \":9000\"\nlisen: \":9000\"\n"))
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "line 2"))
+	_, err = Load(write(t, "retention:\n  max_age: forever\n"))
+	is.True(err != nil)
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/dav-m85/xbellum/admin"
+	"github.com/dav-m85/xbellum/config"
+	"github.com/dav-m85/xbellum/dedup"
+	"github.com/dav-m85/xbellum/store"
+)
+
+// dedupPolicy returns which duplicate survives, as configured.
+func dedupPolicy(conf *config.Config) dedup.Policy {
+	return dedup.Policy{Keep: conf.Dedup.Keep, Folder: conf.Dedup.Folder, Merge: conf.Dedup.Merge}
+}
+
+// dedupOptions are flags of the dedup command.
+type dedupOptions struct {
+	policy      dedup.Policy
+	dryRun      bool
+	interactive bool
+	// head and pick carry choices made with -i.
+	head string
+	pick string
+}
+
+func dedupFlags(fs *flag.FlagSet, conf *config.Config) *dedupOptions {
+	o := &dedupOptions{policy: dedupPolicy(conf)}
+	fs.StringVar(&o.policy.Keep, "keep", o.policy.Keep, "which duplicate to keep: "+strings.Join(dedup.Keeps, ", "))
+	fs.StringVar(&o.policy.Folder, "folder", o.policy.Folder, "folder whose duplicates are kept with -keep folder, like Work/Reading")
+	fs.BoolVar(&o.policy.Merge, "merge", o.policy.Merge, "give the kept bookmark the longest title and every description")
+	fs.BoolVar(&o.dryRun, "dry-run", false, "show what would be removed without recording a revision")
+	fs.BoolVar(&o.dryRun, "n", false, "same as -dry-run")
+	fs.BoolVar(&o.interactive, "i", false, "pick which duplicate to keep, group by group")
+	fs.StringVar(&o.head, "head", "", "revision the choices of -pick were made on")
+	fs.StringVar(&o.pick, "pick", "", "choices like 1:2,3:0, keeping bookmark 2 of group 1 and all of group 3")
+	return o
+}
+
+// parsePick reads -pick into store.Pick choices, groups and bookmarks being
+// numbered from 1, 0 keeping the whole group.
+func parsePick(s string) (map[int]int, error) {
+	choices := make(map[int]int)
+	for _, p := range strings.Split(s, ",") {
+		if p == "" {
+			continue
+		}
+		parts := strings.SplitN(p, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid choice %q, want group:bookmark", p)
+		}
+		g, err1 := strconv.Atoi(parts[0])
+		e, err2 := strconv.Atoi(parts[1])
+		if err1 != nil || err2 != nil || g < 1 || e < 0 {
+			return nil, fmt.Errorf("invalid choice %q, want group:bookmark", p)
+		}
+		choices[g-1] = e - 1
+	}
+	return choices, nil
+}
+
+// dedupCommand removes duplicates of the head revision of st, printing what
+// is kept and dropped.
+func dedupCommand(ctx context.Context, conf *config.Config, st *store.Store, fs *flag.FlagSet, args []string, w io.Writer) error {
+	o := dedupFlags(fs, conf)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := o.policy.Validate(); err != nil {
+		return err
+	}
+	head, groups := st.Duplicates(o.policy)
+	if o.head != "" && o.head != head {
+		return store.ErrStale
+	}
+	choices, err := parsePick(o.pick)
+	if err != nil {
+		return err
+	}
+	if err := store.Pick(groups, choices); err != nil {
+		return err
+	}
+
+	removed := 0
+	for _, g := range groups {
+		fmt.Fprintln(w, g.Key)
+		for i, e := range g.Entries {
+			what := "drop"
+			if g.Keep < 0 || i == g.Keep {
+				what = "keep"
+			}
+			fmt.Fprintf(w, "  %s  %s  %q  %s\n", what, e.Folder(), e.Title, e.Href)
+		}
+		removed += g.Removed()
+	}
+	switch {
+	case removed == 0:
+		fmt.Fprintln(w, "No duplicates to remove")
+	case o.dryRun:
+		fmt.Fprintf(w, "%d duplicate(s) would be removed\n", removed)
+	default:
+		if err := st.Dedup(ctx, head, groups, o.policy.Merge); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "Removed %d duplicate(s), recorded %s\n", removed, st.Head())
+	}
+	return nil
+}
+
+// errAborted is returned when quitting -i.
+var errAborted = errors.New("aborted, nothing changed")
+
+// promptDedup asks which bookmark of each group to keep when req is a dedup
+// with -i, and returns req with the choices. Duplicates are read from disk,
+// the command fails when a server records another revision meanwhile.
+func promptDedup(conf *config.Config, req admin.Request, in io.Reader, out io.Writer) (admin.Request, error) {
+	fs := flag.NewFlagSet("dedup", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	o := dedupFlags(fs, conf)
+	if err := fs.Parse(req.Args[1:]); err != nil || !o.interactive {
+		// Errors are reported by the command
+		return req, nil
+	}
+	if err := o.policy.Validate(); err != nil {
+		return req, err
+	}
+	// Reading is fine while the server runs
+	lib, err := userCollections(conf, req.User)
+	if err != nil {
+		return req, err
+	}
+	defer lib.Close()
+	st, err := lib.Open(req.Collection, false)
+	if err != nil {
+		return req, fmt.Errorf("collection %s: %w", req.Collection, err)
+	}
+	head, groups := st.Duplicates(o.policy)
+
+	sc := bufio.NewScanner(in)
+	var picks []string
+	for gi, g := range groups {
+		fmt.Fprintf(out, "[%d/%d] %s\n", gi+1, len(groups), g.Key)
+		for i, e := range g.Entries {
+			mark := " "
+			if i == g.Keep {
+				mark = "*"
+			}
+			fmt.Fprintf(out, "%s %d) %q in %s", mark, i+1, e.Title, e.Folder())
+			if e.Added != "" {
+				fmt.Fprintf(out, ", added %s", e.Added)
+			}
+			fmt.Fprintf(out, "\n     %s\n", e.Href)
+		}
+		fmt.Fprintln(out, "  0) keep them all")
+		for {
+			fmt.Fprintf(out, "Keep [%d], q to quit: ", g.Keep+1)
+			if !sc.Scan() {
+				return req, errAborted
+			}
+			answer := strings.TrimSpace(sc.Text())
+			if answer == "q" {
+				return req, errAborted
+			}
+			n := g.Keep + 1
+			if answer != "" {
+				var err error
+				if n, err = strconv.Atoi(answer); err != nil || n < 0 || n > len(g.Entries) {
+					continue
+				}
+			}
+			picks = append(picks, fmt.Sprintf("%d:%d", gi+1, n))
+			break
+		}
+	}
+	req.Args = append(req.Args, "-head", head, "-pick", strings.Join(picks, ","))
+	return req, nil
+}
+
+// similarCommand reports clusters of bookmarks likely leading to the same
+// content under different links.
+func similarCommand(ctx context.Context, conf *config.Config, st *store.Store, fs *flag.FlagSet, args []string, w io.Writer) error {
+	minScore := fs.Float64("min", dedup.MinScore, "lowest score reported, from 0 to 1")
+	fetch := fs.Bool("canonical", false, "fetch pages for their canonical link")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var canonical map[string]string
+	if *fetch {
+		var hrefs []string
+		seen := make(map[string]bool)
+		for _, b := range st.Bookmarks() {
+			if !seen[b.Href] {
+				seen[b.Href] = true
+				hrefs = append(hrefs, b.Href)
+			}
+		}
+		canonical = newChecker(conf).Canonicals(ctx, hrefs)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+	clusters := st.Similar(*minScore, canonical)
+	for _, c := range clusters {
+		fmt.Fprintf(w, "%.2f %s\n", c.Score, c.Why)
+		for _, e := range c.Entries {
+			fmt.Fprintf(w, "  %q in %s\n    %s\n", e.Title, e.Folder(), e.Href)
+		}
+	}
+	fmt.Fprintf(w, "%d cluster(s) of similar bookmarks\n", len(clusters))
+	return nil
+}
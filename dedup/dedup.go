@@ -0,0 +1,206 @@
+// Package dedup finds bookmarks leading to the same page, see urlnorm, and
+// picks which one of each group survives.
+package dedup
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dav-m85/xbellum/urlnorm"
+	"github.com/dav-m85/xbellum/xbel"
+)
+
+// Which bookmark of a group a Policy keeps.
+const (
+	// KeepFirst keeps the first one in folder order.
+	KeepFirst = "first"
+	// KeepOldest and KeepNewest go by the added attribute, bookmarks
+	// without it being older than any other.
+	KeepOldest = "oldest"
+	KeepNewest = "newest"
+	// KeepDeepest keeps the one nested in the most folders, likely filed
+	// with more care.
+	KeepDeepest = "deepest"
+	// KeepFolder keeps the one in Policy.Folder or below it.
+	KeepFolder = "folder"
+)
+
+// Keeps lists the valid Policy.Keep values.
+var Keeps = []string{KeepFirst, KeepOldest, KeepNewest, KeepDeepest, KeepFolder}
+
+// Policy tells which bookmark of a group survives. Ties, and groups without
+// a bookmark in Folder, fall back on the first one.
+type Policy struct {
+	// Keep is one of Keeps, KeepFirst when empty.
+	Keep string
+	// Folder is a path of folder titles separated by /, like Work/Reading.
+	Folder string
+	// Merge gives the survivor the longest title of the group and the
+	// descriptions of the others.
+	Merge bool
+}
+
+// Validate tells if p can be used.
+func (p Policy) Validate() error {
+	if p.Keep == "" {
+		return nil
+	}
+	for _, k := range Keeps {
+		if p.Keep == k {
+			if k == KeepFolder && strings.Trim(p.Folder, "/") == "" {
+				return fmt.Errorf("no folder to keep bookmarks of")
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown policy %q, use one of %s", p.Keep, strings.Join(Keeps, ", "))
+}
+
+// Entry is a bookmark of a group.
+type Entry struct {
+	xbel.Bookmark
+	// Path lists titles of folders leading to the bookmark.
+	Path []string
+	// pos is the position of the bookmark in xbel.Each order.
+	pos int
+}
+
+// Folder returns the path of the bookmark, titles separated by /.
+func (e Entry) Folder() string {
+	return strings.Join(e.Path, "/")
+}
+
+// Group is bookmarks with the same link key, see urlnorm.
+type Group struct {
+	Key     string
+	Entries []Entry
+	// Keep is the index of the surviving entry, -1 to keep them all.
+	Keep int
+}
+
+// Removed counts bookmarks the group drops.
+func (g Group) Removed() int {
+	if g.Keep < 0 {
+		return 0
+	}
+	return len(g.Entries) - 1
+}
+
+// Find returns groups of duplicates of x, links being compared by their key
+// with urls, in order of their first bookmark, their survivor picked by p.
+func Find(x *xbel.XBEL, p Policy, urls *urlnorm.Normalizer) []Group {
+	var groups []Group
+	byKey := make(map[string]int)
+	pos := 0
+	xbel.Each(x, func(path []string, b *xbel.Bookmark) {
+		key := urls.Key(b.Href)
+		i, ok := byKey[key]
+		if !ok {
+			i = len(groups)
+			byKey[key] = i
+			groups = append(groups, Group{Key: key})
+		}
+		groups[i].Entries = append(groups[i].Entries, Entry{Bookmark: *b, Path: path, pos: pos})
+		pos++
+	})
+	var dups []Group
+	for _, g := range groups {
+		if len(g.Entries) > 1 {
+			g.Keep = p.choose(g.Entries)
+			dups = append(dups, g)
+		}
+	}
+	return dups
+}
+
+// choose returns the index of the surviving entry.
+func (p Policy) choose(entries []Entry) int {
+	best := 0
+	better := func(a, b Entry) bool { return false }
+	switch p.Keep {
+	case KeepOldest:
+		better = func(a, b Entry) bool { return added(a).Before(added(b)) }
+	case KeepNewest:
+		better = func(a, b Entry) bool { return added(a).After(added(b)) }
+	case KeepDeepest:
+		better = func(a, b Entry) bool { return len(a.Path) > len(b.Path) }
+	case KeepFolder:
+		folder := strings.Trim(p.Folder, "/")
+		in := func(e Entry) bool {
+			f := e.Folder()
+			return f == folder || strings.HasPrefix(f, folder+"/")
+		}
+		better = func(a, b Entry) bool { return in(a) && !in(b) }
+	}
+	for i, e := range entries[1:] {
+		if better(e, entries[best]) {
+			best = i + 1
+		}
+	}
+	return best
+}
+
+// added returns when e was added, zero when unknown.
+func added(e Entry) time.Time {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, e.Added); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// Apply returns x without the duplicates groups drop, groups coming from
+// Find on x. With merge, survivors get the longest title of their group and
+// the descriptions of the others.
+func Apply(x *xbel.XBEL, groups []Group, merge bool) *xbel.XBEL {
+	drop := make(map[int]bool)
+	keep := make(map[int]Group)
+	for _, g := range groups {
+		if g.Keep < 0 {
+			continue
+		}
+		for i, e := range g.Entries {
+			if i != g.Keep {
+				drop[e.pos] = true
+			}
+		}
+		keep[g.Entries[g.Keep].pos] = g
+	}
+	pos := -1
+	return xbel.Walk(x, func(b *xbel.Bookmark) bool {
+		pos++
+		if drop[pos] {
+			return false
+		}
+		if g, ok := keep[pos]; ok && merge {
+			mergeInto(b, g.Entries)
+		}
+		return true
+	})
+}
+
+// mergeInto gives b the longest title of entries, and their descriptions.
+func mergeInto(b *xbel.Bookmark, entries []Entry) {
+	descs := []string{}
+	if b.Desc != "" {
+		descs = append(descs, b.Desc)
+	}
+	for _, e := range entries {
+		if len(e.Title) > len(b.Title) {
+			b.Title = e.Title
+		}
+		if e.Desc == "" {
+			continue
+		}
+		dup := false
+		for _, d := range descs {
+			dup = dup || d == e.Desc
+		}
+		if !dup {
+			descs = append(descs, e.Desc)
+		}
+	}
+	b.Desc = strings.Join(descs, "\n")
+}
@@ -0,0 +1,82 @@
+package dedup
+
+import (
+	"testing"
+
+	"github.com/dav-m85/xbellum/urlnorm"
+	"github.com/dav-m85/xbellum/xbel"
+	"github.com/matryer/is"
+)
+
+func sample() *xbel.XBEL {
+	return &xbel.XBEL{Version: xbel.SUPPORTED_VERSION, Folders: []xbel.Folder{
+		{Title: "Inbox", Bookmarks: []xbel.Bookmark{
+			{Title: "Go", Href: "http://golang.org/", Added: "2021-03-01T10:00:00Z"},
+			{Title: "Other", Href: "https://example.com/"},
+		}},
+		{Title: "Work", Folders: []xbel.Folder{
+			{Title: "Reading", Bookmarks: []xbel.Bookmark{
+				{Title: "The Go language", Href: "https://golang.org", Desc: "spec and all", Added: "2020-01-01T10:00:00Z"},
+			}},
+		}, Bookmarks: []xbel.Bookmark{
+			{Title: "Go", Href: "https://www.golang.org/?utm_source=x", Desc: "home", Added: "2022-01-01T10:00:00Z"},
+		}},
+	}}
+}
+
+func TestFind(t *testing.T) {
+	is := is.New(t)
+
+	for _, tc := range []struct {
+		policy Policy
+		keep   string
+	}{
+		{Policy{Keep: KeepFirst}, "Inbox"},
+		{Policy{Keep: KeepOldest}, "Work/Reading"},
+		{Policy{Keep: KeepNewest}, "Work"},
+		{Policy{Keep: KeepDeepest}, "Work/Reading"},
+		{Policy{Keep: KeepFolder, Folder: "Work"}, "Work"},
+		{Policy{Keep: KeepFolder, Folder: "/Work/Reading/"}, "Work/Reading"},
+		{Policy{Keep: KeepFolder, Folder: "Elsewhere"}, "Inbox"},
+	} {
+		is.NoErr(tc.policy.Validate())
+		groups := Find(sample(), tc.policy, urlnorm.New())
+		is.Equal(len(groups), 1)
+		g := groups[0]
+		is.Equal(len(g.Entries), 3)
+		is.Equal(g.Removed(), 2)
+		is.Equal(g.Entries[g.Keep].Folder(), tc.keep) // survivor
+	}
+
+	is.True(Policy{Keep: "best"}.Validate() != nil)
+	is.True(Policy{Keep: KeepFolder}.Validate() != nil) // no folder
+}
+
+func TestApply(t *testing.T) {
+	is := is.New(t)
+
+	groups := Find(sample(), Policy{Keep: KeepDeepest}, urlnorm.New())
+	x := Apply(sample(), groups, false)
+	bs := xbel.Bookmarks(x)
+	is.Equal(len(bs), 2)
+	is.Equal(bs[0].Title, "Other")
+	is.Equal(bs[1].Desc, "spec and all")
+	// Emptied folders go
+	is.Equal(len(x.Folders), 2)
+	is.Equal(len(x.Folders[1].Bookmarks), 0)
+
+	x = Apply(sample(), groups, true)
+	var kept []*xbel.Bookmark
+	xbel.Each(x, func(path []string, b *xbel.Bookmark) {
+		if b.Title != "Other" {
+			kept = append(kept, b)
+		}
+	})
+	is.Equal(len(kept), 1)
+	is.Equal(kept[0].Title, "The Go language")
+	is.Equal(kept[0].Desc, "spec and all\nhome")
+
+	// Groups kept whole change nothing
+	groups[0].Keep = -1
+	is.Equal(len(xbel.Bookmarks(Apply(sample(), groups, true))), 4)
+}
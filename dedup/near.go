@@ -0,0 +1,268 @@
+package dedup
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/dav-m85/xbellum/urlnorm"
+	"github.com/dav-m85/xbellum/xbel"
+)
+
+// MinScore is the lowest score of clusters worth a look.
+const MinScore = 0.6
+
+// Why two bookmarks are in a Cluster.
+const (
+	SameCanonical = "same canonical page"
+	SameURL       = "same link once amp and mobile variants are ignored"
+	SimilarTitle  = "similar title and link"
+)
+
+// Cluster is bookmarks with different links likely leading to the same
+// content, like AMP pages, mirrors and mobile sites.
+type Cluster struct {
+	Entries []Entry
+	// Score is the confidence, from 0 to 1, of the weakest tie between
+	// entries, Why telling what that tie is.
+	Score float64
+	Why   string
+}
+
+// commonToken is how many bookmarks a title word may appear in before being
+// too common to pair them on.
+const commonToken = 50
+
+// Near returns clusters of bookmarks of x scoring at least minScore, best
+// first. canonical maps links to the canonical URL of their page, when
+// fetched, and may be nil. Exact duplicates, see Find, count as one bookmark,
+// links being compared by their key with urls.
+func Near(x *xbel.XBEL, minScore float64, canonical map[string]string, urls *urlnorm.Normalizer) []Cluster {
+	type item struct {
+		Entry
+		loose  string
+		canon  string
+		title  map[string]bool
+		tokens map[string]bool
+	}
+	var items []item
+	seen := make(map[string]bool)
+	pos := 0
+	xbel.Each(x, func(path []string, b *xbel.Bookmark) {
+		pos++
+		key := urls.Key(b.Href)
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		it := item{
+			Entry:  Entry{Bookmark: *b, Path: path, pos: pos - 1},
+			loose:  loose(urls, b.Href),
+			title:  words(b.Title),
+			tokens: words(linkPath(loose(urls, b.Href))),
+		}
+		if c, ok := canonical[b.Href]; ok {
+			it.canon = loose(urls, c)
+		}
+		items = append(items, it)
+	})
+
+	// Only bookmarks sharing something are compared
+	buckets := make(map[string][]int)
+	for i, it := range items {
+		buckets["l"+it.loose] = append(buckets["l"+it.loose], i)
+		if it.canon != "" {
+			buckets["c"+it.canon] = append(buckets["c"+it.canon], i)
+			// Pages may declare the other link canonical
+			buckets["l"+it.canon] = append(buckets["l"+it.canon], i)
+		}
+		for w := range it.title {
+			buckets["t"+w] = append(buckets["t"+w], i)
+		}
+	}
+
+	type edge struct {
+		a, b  int
+		score float64
+		why   string
+	}
+	var edges []edge
+	compared := make(map[[2]int]bool)
+	for k, b := range buckets {
+		if k[0] == 't' && len(b) > commonToken {
+			continue
+		}
+		for i := 0; i < len(b); i++ {
+			for j := i + 1; j < len(b); j++ {
+				p := [2]int{b[i], b[j]}
+				if p[0] > p[1] {
+					p[0], p[1] = p[1], p[0]
+				}
+				if p[0] == p[1] || compared[p] {
+					continue
+				}
+				compared[p] = true
+				x, y := items[p[0]], items[p[1]]
+				title := jaccard(x.title, y.title)
+				var e edge
+				switch {
+				case x.canon != "" && (x.canon == y.canon || x.canon == y.loose) || y.canon != "" && y.canon == x.loose:
+					e = edge{score: 1, why: SameCanonical}
+				case x.loose == y.loose:
+					e = edge{score: 0.9 + 0.1*title, why: SameURL}
+				case title >= 0.5:
+					// From 0.5 to 0.8, below links telling the same page. Links
+					// must share something, a title like Home being no sign.
+					if tokens := jaccard(x.tokens, y.tokens); tokens > 0 {
+						e = edge{score: 0.4 + 0.2*title + 0.2*tokens, why: SimilarTitle}
+					}
+				}
+				if e.score >= minScore {
+					e.a, e.b = p[0], p[1]
+					edges = append(edges, e)
+				}
+			}
+		}
+	}
+
+	// Strongest ties first, a cluster scoring as its weakest one
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].score != edges[j].score {
+			return edges[i].score > edges[j].score
+		}
+		return edges[i].a < edges[j].a || edges[i].a == edges[j].a && edges[i].b < edges[j].b
+	})
+	parent := make([]int, len(items))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	weakest := make(map[int]edge)
+	for _, e := range edges {
+		ra, rb := find(e.a), find(e.b)
+		if ra == rb {
+			continue
+		}
+		// Edges come weaker and weaker
+		parent[rb] = ra
+		delete(weakest, rb)
+		weakest[ra] = e
+	}
+
+	byRoot := make(map[int]*Cluster)
+	var clusters []*Cluster
+	for i, it := range items {
+		r := find(i)
+		w, ok := weakest[r]
+		if !ok {
+			continue
+		}
+		c := byRoot[r]
+		if c == nil {
+			c = &Cluster{Score: w.score, Why: w.why}
+			byRoot[r] = c
+			clusters = append(clusters, c)
+		}
+		c.Entries = append(c.Entries, it.Entry)
+	}
+	sort.SliceStable(clusters, func(i, j int) bool { return clusters[i].Score > clusters[j].Score })
+	res := make([]Cluster, len(clusters))
+	for i, c := range clusters {
+		res[i] = *c
+	}
+	return res
+}
+
+// mobile are host prefixes of mobile and AMP versions of sites.
+var mobile = []string{"amp.", "m.", "mobile.", "touch."}
+
+// loose returns the key of href with urls, without the scheme, and without
+// what tells AMP and mobile versions of pages.
+func loose(urls *urlnorm.Normalizer, href string) string {
+	u, err := url.Parse(urls.Key(href))
+	if err != nil || u.Host == "" {
+		return href
+	}
+	host, p := u.Host, u.EscapedPath()
+	// Pages served from AMP caches, like /c/s/example.com/story
+	if strings.HasSuffix(host, ".cdn.ampproject.org") || host == "google.com" && strings.HasPrefix(p, "/amp/") {
+		rest := strings.SplitN(strings.TrimPrefix(p, "/"), "/", 4)[1:]
+		if len(rest) > 0 && rest[0] == "s" {
+			rest = rest[1:]
+		}
+		if len(rest) > 0 {
+			host, p = strings.TrimPrefix(rest[0], "www."), ""
+			if len(rest) > 1 {
+				p = "/" + strings.Join(rest[1:], "/")
+			}
+		}
+	}
+	// Once, and only when a domain remains: amp.dev or mobile.de are sites
+	for _, m := range mobile {
+		if rest := strings.TrimPrefix(host, m); rest != host {
+			if strings.Contains(rest, ".") {
+				host = rest
+			}
+			break
+		}
+	}
+	var segs []string
+	for _, s := range strings.Split(p, "/") {
+		if s == "amp" {
+			continue
+		}
+		s = strings.Replace(s, ".amp.", ".", 1)
+		segs = append(segs, strings.TrimSuffix(s, ".amp"))
+	}
+	p = strings.TrimSuffix(strings.Join(segs, "/"), "/")
+	q := u.Query()
+	q.Del("amp")
+	if v := q.Get("outputType"); v == "amp" {
+		q.Del("outputType")
+	}
+	key := host + p
+	if enc := q.Encode(); enc != "" {
+		key += "?" + enc
+	}
+	return key
+}
+
+// linkPath returns the path and query of a loose key.
+func linkPath(key string) string {
+	if i := strings.IndexByte(key, '/'); i >= 0 {
+		return key[i:]
+	}
+	return ""
+}
+
+// words returns the set of lowercase words and numbers of s.
+func words(s string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		set[w] = true
+	}
+	return set
+}
+
+// jaccard tells how alike two sets are, from 0 to 1.
+func jaccard(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	common := 0
+	for w := range a {
+		if b[w] {
+			common++
+		}
+	}
+	return float64(common) / float64(len(a)+len(b)-common)
+}
@@ -0,0 +1,90 @@
+package dedup
+
+import (
+	"testing"
+
+	"github.com/dav-m85/xbellum/urlnorm"
+	"github.com/dav-m85/xbellum/xbel"
+	"github.com/matryer/is"
+)
+
+func TestNear(t *testing.T) {
+	is := is.New(t)
+	x := &xbel.XBEL{Version: xbel.SUPPORTED_VERSION, Folders: []xbel.Folder{
+		{Title: "News", Bookmarks: []xbel.Bookmark{
+			{Title: "Rust 2.0 released", Href: "https://news.example.com/2021/rust-2-released"},
+			{Title: "Rust 2.0 released | News", Href: "https://news-example-com.cdn.ampproject.org/c/s/news.example.com/2021/rust-2-released/amp"},
+			{Title: "Rust 2.0 released", Href: "https://m.news.example.com/2021/rust-2-released"},
+			// An exact duplicate counts once
+			{Title: "Rust 2.0 released", Href: "http://news.example.com/2021/rust-2-released/"},
+		}},
+		{Title: "Docs", Bookmarks: []xbel.Bookmark{
+			{Title: "The Go Programming Language Specification", Href: "https://golang.org/ref/spec"},
+			{Title: "The Go Programming Language Specification", Href: "https://go.dev/ref/spec"},
+			{Title: "Short link", Href: "https://golang.org/s/spec"},
+			{Title: "Home", Href: "https://a.example.com/"},
+			{Title: "Home", Href: "https://b.example.com/"},
+		}},
+	}}
+
+	clusters := Near(x, MinScore, nil, urlnorm.New())
+	is.Equal(len(clusters), 2)
+	is.Equal(len(clusters[0].Entries), 3) // AMP and mobile versions
+	is.Equal(clusters[0].Why, SameURL)
+	is.True(clusters[0].Score > 0.9 && clusters[0].Score < 1)
+	is.Equal(clusters[1].Entries[0].Href, "https://golang.org/ref/spec") // mirror
+	is.Equal(clusters[1].Entries[1].Href, "https://go.dev/ref/spec")
+	is.Equal(clusters[1].Score, 0.8) // titles alone never make it sure
+	is.Equal(clusters[1].Why, SimilarTitle)
+
+	// Pages telling their canonical link
+	clusters = Near(x, MinScore, map[string]string{
+		"https://golang.org/s/spec":   "https://go.dev/ref/spec",
+		"https://golang.org/ref/spec": "https://go.dev/ref/spec",
+	}, urlnorm.New())
+	is.Equal(len(clusters), 2)
+	is.Equal(len(clusters[0].Entries), 3)
+	is.Equal(clusters[0].Entries[2].Title, "Short link")
+	is.Equal(clusters[0].Why, SameCanonical)
+	is.Equal(clusters[0].Score, 1.0)
+
+	clusters = Near(x, 0.99, nil, urlnorm.New())
+	is.Equal(len(clusters), 1)
+	is.Equal(len(clusters[0].Entries), 2) // the AMP page titled differently is out
+}
+
+func TestNearTitles(t *testing.T) {
+	is := is.New(t)
+	x := &xbel.XBEL{Version: xbel.SUPPORTED_VERSION, Folders: []xbel.Folder{{Title: "Go", Bookmarks: []xbel.Bookmark{
+		{Title: "Go 1.18 is released with generics", Href: "https://blog.golang.org/go1.18"},
+		{Title: "Go 1.18 released - generics land", Href: "https://go.dev/blog/go1.18"},
+		{Title: "Go 1.18 release notes", Href: "https://go.dev/doc/go1.18"},
+	}}}}
+
+	clusters := Near(x, MinScore, nil, urlnorm.New())
+	is.Equal(len(clusters), 1)
+	is.Equal(clusters[0].Why, SimilarTitle)
+	is.Equal(len(clusters[0].Entries), 2) // release notes are another page
+	is.Equal(clusters[0].Entries[1].Href, "https://go.dev/blog/go1.18")
+	is.True(clusters[0].Score >= MinScore && clusters[0].Score < 0.8)
+}
+
+func TestLoose(t *testing.T) {
+	is := is.New(t)
+	n := urlnorm.New()
+	for _, hrefs := range [][]string{
+		{"https://example.com/a/b", "http://amp.example.com/a/b", "https://example.com/amp/a/b", "https://example.com/a/b/amp",
+			"https://example.com/a/b?amp=1", "https://www-example-com.cdn.ampproject.org/c/s/www.example.com/a/b",
+			"https://www.google.com/amp/s/example.com/a/b"},
+		{"https://example.com/a.html", "https://example.com/a.amp.html", "https://mobile.example.com/a.html"},
+	} {
+		for _, h := range hrefs[1:] {
+			is.Equal(loose(n, h), loose(n, hrefs[0])) // same page
+		}
+	}
+	is.True(loose(n, "https://example.com/a") != loose(n, "https://example.com/b"))
+	// Sites of their own, no domain left once stripped
+	is.True(loose(n, "https://amp.dev/a") != loose(n, "https://dev/a"))
+	is.True(loose(n, "https://mobile.de/a") != loose(n, "https://de/a"))
+	is.True(loose(n, "https://m.mobile.example.com/a") != loose(n, "https://example.com/a")) // once
+}
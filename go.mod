@@ -3,15 +3,16 @@ module github.com/dav-m85/xbellum
 go 1.17
 
 require (
+	github.com/matryer/is v1.4.0
 	go.uber.org/zap v1.19.0
 	golang.org/x/crypto v0.0.0-20210513164829-c07d793c2f9a
 	golang.org/x/net v0.0.0-20210614182718-04defd469f4e
+	gopkg.in/yaml.v2 v2.4.0
 )
 
 require (
-	github.com/matryer/is v1.4.0 // indirect
 	go.uber.org/atomic v1.7.0 // indirect
 	go.uber.org/multierr v1.6.0 // indirect
+	golang.org/x/sys v0.0.0-20210423082822-04245dca01da // indirect
 	golang.org/x/tools v0.0.0-20191112195655-aa38f8e97acc // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
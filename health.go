@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync/atomic"
+)
+
+// health answers liveness and readiness probes.
+type health struct {
+	root string
+	// stopping is set once shutting down, so that no new traffic comes
+	stopping int32
+}
+
+// live tells the process is up.
+func (h *health) live(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+// ready tells the server can take requests: stores are loaded, which
+// happens before listening, the data root is writable and it is not
+// shutting down.
+func (h *health) ready(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&h.stopping) == 1 {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	if err := writable(h.root); err != nil {
+		http.Error(w, "data root not writable: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+func (h *health) stop() {
+	atomic.StoreInt32(&h.stopping, 1)
+}
+
+func writable(dir string) error {
+	f, err := ioutil.TempFile(dir, ".readyz")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dav-m85/xbellum/checker"
+	"github.com/dav-m85/xbellum/config"
+	"github.com/dav-m85/xbellum/jobs"
+	"github.com/dav-m85/xbellum/store"
+)
+
+// backupPrefix starts the name of backup archives.
+const backupPrefix = "xbellum-"
+
+// newScheduler sets up maintenance jobs over the collections of usernames,
+// the shared data root when empty.
+func newScheduler(conf *config.Config, tn *tenants, usernames []string) *jobs.Scheduler {
+	if len(usernames) == 0 {
+		usernames = []string{""}
+	}
+	// each calls f with every collection, stopping at the first error
+	each := func(ctx context.Context, f func(who string, st *store.Store) error) error {
+		for _, u := range usernames {
+			t, err := tn.get(u)
+			if err != nil {
+				return err
+			}
+			for _, name := range t.lib.Names() {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				st, err := t.lib.Open(name, false)
+				if err != nil {
+					return err
+				}
+				who := name
+				if u != "" {
+					who = u + "/" + name
+				}
+				if err := f(who, st); err != nil {
+					return fmt.Errorf("%s: %w", who, err)
+				}
+			}
+		}
+		return nil
+	}
+
+	backupDir := conf.Jobs.BackupDir
+	if backupDir == "" {
+		backupDir = filepath.Join(conf.Root, "backups")
+	}
+
+	s := jobs.New()
+	// Schedules are validated with the configuration
+	s.Add("check", conf.Jobs.Check, func(ctx context.Context, l *jobs.Log) error {
+		c := newChecker(conf)
+		f := fixes(conf)
+		return each(ctx, func(who string, st *store.Store) error {
+			l.Printf("checking %s", who)
+			if err := checkLinks(ctx, c, st, conf.LinkCheck.DeadAfter, l.Printf); err != nil {
+				return err
+			}
+			if f.Dead == store.DeadKeep && !f.Redirects {
+				return nil
+			}
+			changes, err := st.FixLinks(ctx, f, false)
+			for _, c := range changes {
+				l.Printf("%s", c)
+			}
+			if err == nil && len(changes) > 0 {
+				l.Printf("recorded %s", st.Head())
+			}
+			return err
+		})
+	})
+	s.Add("prune", conf.Jobs.Prune, func(ctx context.Context, l *jobs.Log) error {
+		r := store.Retention{Keep: conf.Retention.Keep, MaxAge: conf.Retention.MaxAge.Duration}
+		return each(ctx, func(who string, st *store.Store) error {
+			removed, err := st.Prune(r)
+			for _, id := range removed {
+				l.Printf("%s: removed %s", who, id)
+			}
+			return err
+		})
+	})
+	s.Add("backup", conf.Jobs.Backup, func(ctx context.Context, l *jobs.Log) error {
+		return backup(ctx, conf.Root, backupDir, conf.Jobs.BackupKeep, l)
+	})
+	s.Add("report", conf.Jobs.Report, func(ctx context.Context, l *jobs.Log) error {
+		return each(ctx, func(who string, st *store.Store) error {
+			revisions, bookmarks := st.Stats()
+			db, err := st.Links()
+			if err != nil {
+				return err
+			}
+			states := make(map[string]int)
+			for _, b := range st.Bookmarks() {
+				if h, ok := db.Get(b.Href); ok {
+					states[h.State(conf.LinkCheck.DeadAfter)]++
+				}
+			}
+			l.Printf("%s: %d revision(s), %d bookmark(s), %d dead, %d flaky, %d redirected link(s)",
+				who, revisions, bookmarks, states[checker.StateDead], states[checker.StateFlaky], states[checker.StateRedirected])
+			return nil
+		})
+	})
+	return s
+}
+
+// backup archives the data root into dir as a .tar.gz, leaving dir itself
+// out, then removes archives beyond the keep latest.
+func backup(ctx context.Context, root, dir string, keep int, l *jobs.Log) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	skip, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, ".backup-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	files := 0
+	err = filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if abs, _ := filepath.Abs(p); abs == skip {
+			return filepath.SkipDir
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			hdr.Name += "/"
+			return tw.WriteHeader(hdr)
+		}
+		// Read first, files may be rewritten meanwhile
+		data, err := ioutil.ReadFile(p)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		hdr.Size = int64(len(data))
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		files++
+		_, err = tw.Write(data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for _, c := range []io.Closer{tw, gz, f} {
+		if err := c.Close(); err != nil {
+			return err
+		}
+	}
+	fn := filepath.Join(dir, backupPrefix+time.Now().Format("20060102-150405")+".tar.gz")
+	if err := os.Rename(f.Name(), fn); err != nil {
+		return err
+	}
+	l.Printf("archived %d file(s) into %s", files, fn)
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var archives []string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), backupPrefix) && strings.HasSuffix(e.Name(), ".tar.gz") {
+			archives = append(archives, e.Name())
+		}
+	}
+	// Names sort by date
+	sort.Strings(archives)
+	for len(archives) > keep {
+		if err := os.Remove(filepath.Join(dir, archives[0])); err != nil {
+			return err
+		}
+		l.Printf("removed %s", archives[0])
+		archives = archives[1:]
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestParse(t *testing.T) {
+	is := is.New(t)
+
+	for _, bad := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "@every 1s", "@yearly"} {
+		_, err := Parse(bad)
+		is.True(err != nil) // bad spec
+	}
+
+	at := func(s string) time.Time {
+		tm, err := time.Parse("2006-01-02 15:04", s)
+		is.NoErr(err)
+		return tm
+	}
+	// 2021-06-02 is a Wednesday
+	now := at("2021-06-02 10:30")
+	for _, tc := range []struct{ spec, next string }{
+		{"* * * * *", "2021-06-02 10:31"},
+		{"@hourly", "2021-06-02 11:00"},
+		{"@daily", "2021-06-03 00:00"},
+		{"@weekly", "2021-06-06 00:00"},
+		{"@monthly", "2021-07-01 00:00"},
+		{"@every 2h", "2021-06-02 12:30"},
+		{"*/15 * * * *", "2021-06-02 10:45"},
+		{"0 4 * * *", "2021-06-03 04:00"},
+		{"30 9-17/4 * * 1-5", "2021-06-02 13:30"},
+		{"0 0 * * 6,7", "2021-06-05 00:00"},
+		{"0 0 29 2 *", "2024-02-29 00:00"},
+		// Either day field matches when both are set
+		{"0 12 15 * 5", "2021-06-04 12:00"},
+	} {
+		s, err := Parse(tc.spec)
+		is.NoErr(err)
+		is.Equal(s.Next(now).Format("2006-01-02 15:04"), tc.next) // next run
+	}
+}
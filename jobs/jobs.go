@@ -0,0 +1,256 @@
+// Package jobs runs maintenance tasks on schedules inside the server, and on
+// demand from the web UI.
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Func is the work of a job, which should stop when ctx is done.
+type Func func(ctx context.Context, l *Log) error
+
+var (
+	ErrUnknown = errors.New("unknown job")
+	ErrRunning = errors.New("job already running")
+)
+
+// maxLines bounds the log kept of a run.
+const maxLines = 500
+
+// Log collects what a run prints, which also goes to the server log.
+type Log struct {
+	job   string
+	mu    sync.Mutex
+	lines []string
+}
+
+// Printf adds a line to the log.
+func (l *Log) Printf(format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
+	zap.L().Info(msg, zap.String("job", l.job))
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.lines) == maxLines {
+		l.lines = append(l.lines[:0], l.lines[1:]...)
+	}
+	l.lines = append(l.lines, time.Now().Format("15:04:05 ")+msg)
+}
+
+// Lines returns what was printed so far.
+func (l *Log) Lines() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.lines...)
+}
+
+// Status is the state of a job, as of its last run.
+type Status struct {
+	Name string
+	Spec string
+	// Next is zero for jobs only run on demand.
+	Next    time.Time
+	Started time.Time
+	Ended   time.Time
+	Running bool
+	Err     string
+	Log     []string
+}
+
+type job struct {
+	name     string
+	spec     string
+	schedule Schedule
+	run      Func
+
+	next    time.Time
+	started time.Time
+	ended   time.Time
+	running bool
+	err     error
+	log     *Log
+}
+
+// Scheduler runs jobs when their schedule is due, one run of a job at a time.
+type Scheduler struct {
+	// CSRF returns the token web UI forms carry in a csrf field, if any.
+	CSRF func(r *http.Request) string
+
+	mu     sync.Mutex
+	jobs   []*job
+	ctx    context.Context
+	cancel context.CancelFunc
+	wake   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// New returns a scheduler without jobs.
+func New() *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{ctx: ctx, cancel: cancel, wake: make(chan struct{}, 1)}
+}
+
+// Add registers a job run on spec, as understood by Parse, or only on demand
+// when spec is empty.
+func (s *Scheduler) Add(name, spec string, f Func) error {
+	j := &job{name: name, spec: spec, run: f}
+	if spec != "" {
+		var err error
+		if j.schedule, err = Parse(spec); err != nil {
+			return err
+		}
+		j.next = j.schedule.Next(time.Now())
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, o := range s.jobs {
+		if o.name == name {
+			return fmt.Errorf("job %s added twice", name)
+		}
+	}
+	s.jobs = append(s.jobs, j)
+	s.poke()
+	return nil
+}
+
+// poke wakes the loop up to consider new schedules.
+func (s *Scheduler) poke() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start runs jobs on their schedule until Stop.
+func (s *Scheduler) Start() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			s.mu.Lock()
+			var next time.Time
+			for _, j := range s.jobs {
+				if !j.next.IsZero() && (next.IsZero() || j.next.Before(next)) {
+					next = j.next
+				}
+			}
+			s.mu.Unlock()
+
+			var due <-chan time.Time
+			var timer *time.Timer
+			if !next.IsZero() {
+				timer = time.NewTimer(time.Until(next))
+				due = timer.C
+			}
+			select {
+			case <-s.ctx.Done():
+				if timer != nil {
+					timer.Stop()
+				}
+				return
+			case <-s.wake:
+				if timer != nil {
+					timer.Stop()
+				}
+			case now := <-due:
+				s.mu.Lock()
+				for _, j := range s.jobs {
+					if j.next.IsZero() || j.next.After(now) {
+						continue
+					}
+					j.next = j.schedule.Next(now)
+					if j.running {
+						zap.L().Warn("job still running, run skipped", zap.String("job", j.name))
+						continue
+					}
+					s.start(j)
+				}
+				s.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// Stop cancels running jobs and waits for them to return.
+func (s *Scheduler) Stop() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// Run starts the named job now, outside of its schedule.
+func (s *Scheduler) Run(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx.Err() != nil {
+		return s.ctx.Err()
+	}
+	for _, j := range s.jobs {
+		if j.name != name {
+			continue
+		}
+		if j.running {
+			return ErrRunning
+		}
+		s.start(j)
+		return nil
+	}
+	return ErrUnknown
+}
+
+// start runs j in the background, s.mu being held.
+func (s *Scheduler) start(j *job) {
+	j.running = true
+	j.started = time.Now()
+	j.ended = time.Time{}
+	j.err = nil
+	j.log = &Log{job: j.name}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		zap.L().Info("job started", zap.String("job", j.name))
+		err := j.run(s.ctx, j.log)
+		if err != nil {
+			zap.L().Error("job failed", zap.String("job", j.name), zap.Error(err))
+		} else {
+			zap.L().Info("job done", zap.String("job", j.name), zap.Duration("took", time.Since(j.started)))
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		j.running = false
+		j.ended = time.Now()
+		j.err = err
+	}()
+}
+
+// Jobs returns the status of jobs, sorted by name.
+func (s *Scheduler) Jobs() []Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := make([]Status, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		e := Status{
+			Name:    j.name,
+			Spec:    j.spec,
+			Next:    j.next,
+			Started: j.started,
+			Ended:   j.ended,
+			Running: j.running,
+		}
+		if j.err != nil {
+			e.Err = j.err.Error()
+		}
+		if j.log != nil {
+			e.Log = j.log.Lines()
+		}
+		st = append(st, e)
+	}
+	sort.Slice(st, func(a, b int) bool { return st[a].Name < st[b].Name })
+	return st
+}
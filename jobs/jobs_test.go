@@ -0,0 +1,114 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestScheduler(t *testing.T) {
+	is := is.New(t)
+	s := New()
+	release := make(chan struct{})
+	is.NoErr(s.Add("slow", "", func(ctx context.Context, l *Log) error {
+		l.Printf("waiting")
+		select {
+		case <-release:
+			return errors.New("boom")
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}))
+	is.NoErr(s.Add("daily", "@daily", func(ctx context.Context, l *Log) error { return nil }))
+	is.True(s.Add("daily", "", nil) != nil)    // duplicate name
+	is.True(s.Add("bad", "* * *", nil) != nil) // bad schedule
+	s.Start()
+
+	is.Equal(s.Run("nope"), ErrUnknown)
+	is.NoErr(s.Run("slow"))
+	is.Equal(s.Run("slow"), ErrRunning)
+
+	st := s.Jobs()
+	is.Equal(len(st), 2)
+	is.Equal(st[0].Name, "daily")
+	is.True(!st[0].Next.IsZero()) // scheduled
+	is.True(st[1].Next.IsZero())  // on demand
+	is.True(st[1].Running)
+
+	close(release)
+	for s.Jobs()[1].Running {
+		time.Sleep(time.Millisecond)
+	}
+	st = s.Jobs()
+	is.Equal(st[1].Err, "boom")
+	is.Equal(len(st[1].Log), 1)
+	is.True(strings.HasSuffix(st[1].Log[0], " waiting"))
+
+	// Stop cancels runs in flight
+	release = make(chan struct{})
+	is.NoErr(s.Run("slow"))
+	s.Stop()
+	is.Equal(s.Jobs()[1].Err, context.Canceled.Error())
+	is.True(s.Run("slow") != nil) // stopped
+}
+
+func TestEvery(t *testing.T) {
+	is := is.New(t)
+	s := New()
+	runs := make(chan struct{}, 1)
+	is.NoErr(s.Add("tick", "@every 1m", func(ctx context.Context, l *Log) error {
+		runs <- struct{}{}
+		return nil
+	}))
+	// Due now rather than in a minute
+	s.jobs[0].next = time.Now()
+	s.Start()
+	defer s.Stop()
+	select {
+	case <-runs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("job not run")
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	is := is.New(t)
+	s := New()
+	done := make(chan struct{})
+	is.NoErr(s.Add("report", "0 6 * * 1", func(ctx context.Context, l *Log) error {
+		defer close(done)
+		l.Printf("all <good>")
+		return nil
+	}))
+	s.CSRF = func(r *http.Request) string { return "tok" }
+
+	post := func(name string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/info/jobs", strings.NewReader(url.Values{"run": {name}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+	rec := post("nope")
+	is.Equal(rec.Code, http.StatusOK)
+	is.True(strings.Contains(rec.Body.String(), ErrUnknown.Error()))
+
+	is.Equal(post("report").Code, http.StatusSeeOther)
+	<-done
+	s.Stop()
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("GET", "/info/jobs", nil))
+	is.Equal(rec.Code, http.StatusOK)
+	body := rec.Body.String()
+	is.True(strings.Contains(body, "0 6 * * 1"))
+	is.True(strings.Contains(body, `value="tok"`))
+	is.True(strings.Contains(body, "all &lt;good&gt;")) // escaped log
+}
@@ -0,0 +1,69 @@
+package jobs
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/dav-m85/xbellum/logging"
+	"github.com/dav-m85/xbellum/proxy"
+	"go.uber.org/zap"
+)
+
+var jobsTpl = template.Must(template.New("jobs").Parse(`
+<html>
+<body>
+<p><a href="{{.Prefix}}/info">Bookmarks</a></p>
+<h2>Jobs</h2>
+{{if .Error}}<p style="color: red">{{.Error}}</p>{{end}}
+<table>
+<tr><th>Job</th><th>Schedule</th><th>Last run</th><th>Outcome</th><th>Next run</th><th></th></tr>
+{{range .Jobs}}
+<tr>
+	<td><a href="#{{.Name}}">{{.Name}}</a></td>
+	<td>{{if .Spec}}{{.Spec}}{{else}}on demand{{end}}</td>
+	<td>{{if not .Started.IsZero}}{{.Started.Format "2006-01-02 15:04:05"}}{{else}}never{{end}}</td>
+	<td>{{if .Running}}running{{else if .Err}}failed: {{.Err}}{{else if not .Ended.IsZero}}done in {{.Ended.Sub .Started}}{{end}}</td>
+	<td>{{if not .Next.IsZero}}{{.Next.Format "2006-01-02 15:04"}}{{end}}</td>
+	<td><form method="post">
+		<input type="hidden" name="csrf" value="{{$.CSRF}}">
+		<input type="hidden" name="run" value="{{.Name}}">
+		<input type="submit" value="Run now"{{if .Running}} disabled{{end}}>
+	</form></td>
+</tr>
+{{end}}
+</table>
+{{range .Jobs}}{{if .Log}}
+<h3 id="{{.Name}}">Last run of {{.Name}}</h3>
+<pre>{{range .Log}}{{.}}
+{{end}}</pre>
+{{end}}{{end}}
+</body>
+</html>
+`))
+
+// ServeHTTP lists jobs with the log of their last run, and starts the one
+// posted in the run field.
+func (s *Scheduler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var errMsg string
+	if r.Method == http.MethodPost {
+		if err := s.Run(r.FormValue("run")); err != nil {
+			errMsg = err.Error()
+		} else {
+			http.Redirect(w, r, proxy.Prefix(r)+r.URL.Path, http.StatusSeeOther)
+			return
+		}
+	}
+	var csrf string
+	if s.CSRF != nil {
+		csrf = s.CSRF(r)
+	}
+	err := jobsTpl.Execute(w, map[string]interface{}{
+		"Prefix": proxy.Prefix(r),
+		"Jobs":   s.Jobs(),
+		"CSRF":   csrf,
+		"Error":  errMsg,
+	})
+	if err != nil {
+		logging.From(r.Context()).Error("rendering failed", zap.Error(err))
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dav-m85/xbellum/jobs"
+	"github.com/matryer/is"
+)
+
+func TestBackup(t *testing.T) {
+	is := is.New(t)
+	root := t.TempDir()
+	is.NoErr(os.MkdirAll(filepath.Join(root, "users", "alice"), 0777))
+	is.NoErr(ioutil.WriteFile(filepath.Join(root, "bkm_000000.xbel"), []byte("a"), 0666))
+	is.NoErr(ioutil.WriteFile(filepath.Join(root, "users", "alice", "bkm_000000.xbel"), []byte("b"), 0666))
+
+	// Backups kept in the data root, with older archives to prune
+	dir := filepath.Join(root, "backups")
+	is.NoErr(os.MkdirAll(dir, 0700))
+	for _, name := range []string{"xbellum-20200101-000000.tar.gz", "xbellum-20210101-000000.tar.gz", "notes.txt"} {
+		is.NoErr(ioutil.WriteFile(filepath.Join(dir, name), nil, 0666))
+	}
+
+	is.NoErr(backup(context.Background(), root, dir, 1, &jobs.Log{}))
+
+	entries, err := ioutil.ReadDir(dir)
+	is.NoErr(err)
+	is.Equal(len(entries), 2)
+	is.Equal(entries[0].Name(), "notes.txt") // not an archive
+	archive := entries[1].Name()
+	is.True(strings.HasPrefix(archive, backupPrefix+"20"))
+	is.True(archive > "xbellum-20210101-000000.tar.gz")
+
+	f, err := os.Open(filepath.Join(dir, archive))
+	is.NoErr(err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	is.NoErr(err)
+	tr := tar.NewReader(gz)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		is.NoErr(err)
+		names = append(names, hdr.Name)
+	}
+	is.Equal(names, []string{"bkm_000000.xbel", "users/", "users/alice/", "users/alice/bkm_000000.xbel"}) // backups left out
+}
@@ -0,0 +1,84 @@
+// Package logging sets up zap loggers and carries request IDs in contexts,
+// so that what a request causes down to the store can be told apart.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// New returns a logger at level (debug, info, warn or error) writing format
+// (console or json) to stderr.
+func New(level, format string) (*zap.Logger, error) {
+	var lvl zapcore.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+	var cfg zap.Config
+	switch format {
+	case "json":
+		cfg = zap.NewProductionConfig()
+		cfg.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	case "console":
+		cfg = zap.NewDevelopmentConfig()
+		cfg.Development = false
+		cfg.DisableStacktrace = true
+		cfg.EncoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+	default:
+		return nil, fmt.Errorf("invalid log format %q", format)
+	}
+	cfg.Level = zap.NewAtomicLevelAt(lvl)
+	cfg.Sampling = nil
+	return cfg.Build()
+}
+
+// Setup makes a logger the global one.
+func Setup(level, format string) error {
+	l, err := New(level, format)
+	if err != nil {
+		return err
+	}
+	zap.ReplaceGlobals(l)
+	return nil
+}
+
+type ctxKey int
+
+const requestIDKey ctxKey = 0
+
+// NewRequestID returns a random ID.
+func NewRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// WithRequestID returns a context carrying id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the request ID of ctx, empty when there is none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// From returns the global logger, with the request ID of ctx if any.
+func From(ctx context.Context) *zap.Logger {
+	l := zap.L()
+	if ctx == nil {
+		return l
+	}
+	if id := RequestID(ctx); id != "" {
+		l = l.With(zap.String("request_id", id))
+	}
+	return l
+}
@@ -0,0 +1,59 @@
+package logging
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/matryer/is"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestNew(t *testing.T) {
+	is := is.New(t)
+
+	_, err := New("info", "json")
+	is.NoErr(err)
+	_, err = New("debug", "console")
+	is.NoErr(err)
+	_, err = New("loud", "json")
+	is.True(err != nil)
+	_, err = New("info", "xml")
+	is.True(err != nil)
+}
+
+func TestHandler(t *testing.T) {
+	is := is.New(t)
+
+	core, logs := observer.New(zap.DebugLevel)
+	defer zap.ReplaceGlobals(zap.New(core))()
+
+	var got string
+	h := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = RequestID(r.Context())
+		From(r.Context()).Debug("inside")
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("PUT", "/a.xbel", nil))
+	is.Equal(len(got), 16)
+	is.Equal(w.Header().Get(RequestIDHeader), got)
+	is.Equal(logs.Len(), 2)
+	for _, e := range logs.TakeAll() {
+		is.Equal(e.ContextMap()["request_id"], got)
+	}
+
+	// Sensible IDs from proxies are kept, others replaced
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set(RequestIDHeader, "abc-123")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	is.Equal(got, "abc-123")
+	r.Header.Set(RequestIDHeader, "bad id\n")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	is.True(got != "bad id\n")
+
+	is.Equal(RequestID(context.Background()), "")
+}
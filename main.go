@@ -1,157 +1,505 @@
 package main
 
 import (
-	"bytes"
+	"bufio"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
-	"net"
-	"net/http"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/dav-m85/xbellum/admin"
+	"github.com/dav-m85/xbellum/auth"
+	"github.com/dav-m85/xbellum/checker"
+	"github.com/dav-m85/xbellum/config"
+	"github.com/dav-m85/xbellum/logging"
 	"github.com/dav-m85/xbellum/store"
+	"github.com/dav-m85/xbellum/urlnorm"
 	"github.com/dav-m85/xbellum/vfs"
-	"github.com/dav-m85/xbellum/xbel"
-	"golang.org/x/crypto/bcrypt"
-	"golang.org/x/net/webdav"
+	dav "github.com/dav-m85/xbellum/webdav"
+	"go.uber.org/zap"
 )
 
-var secret string = os.Getenv("SECRET")
-var root string = os.Getenv("ROOT")
+// defaultConfigFile is read when present and no other file is given.
+const defaultConfigFile = "xbellum.yml"
 
 func main() {
-	var dead bool
-	flag.BoolVar(&dead, "c", false, "check for dead")
+	var collection, user, configFile string
+	flag.StringVar(&collection, "collection", store.DefaultCollection, "bookmark collection to work on")
+	flag.StringVar(&user, "user", "", "user whose bookmarks to work on, when running with users")
+	flag.StringVar(&configFile, "config", os.Getenv("CONFIG"), "configuration file, "+defaultConfigFile+" if present")
 	flag.Parse()
 
-	if root == "" {
-		root = "./data"
+	if configFile == "" {
+		if _, err := os.Stat(defaultConfigFile); err == nil {
+			configFile = defaultConfigFile
+		}
 	}
 
 	args := flag.Args()
-	st := store.NewStore(root)
-
 	if len(args) == 0 {
 		args = append(args, "")
 	}
 
+	if args[0] == "hash-password" {
+		hashPassword(args[1:])
+		return
+	}
+
+	conf, err := config.Load(configFile)
+	if args[0] == "config" {
+		if len(args) < 2 || args[1] != "check" {
+			log.Fatalln("Usage: go main.go config check")
+		}
+		if err == nil {
+			_, err = configUsers(conf)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println("Configuration OK")
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := logging.Setup(conf.Log.Level, conf.Log.Format); err != nil {
+		log.Fatal(err)
+	}
+	defer zap.L().Sync()
+
+	if user != "" && !dav.ValidUsername(user) {
+		log.Fatalf("invalid user %q", user)
+	}
+	if args[0] == "app-password" {
+		appPassword(appPasswords(conf.Root, user), args[1:])
+		return
+	}
+	if args[0] == "token" {
+		users, err := configUsers(conf)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if _, ok := users[user]; !ok && (users != nil || user != "") {
+			log.Fatalf("unknown user %q, pick one with -user", user)
+		}
+		token(auth.NewTokens(filepath.Join(conf.Root, auth.TokensFile)), user, args[1:])
+		return
+	}
 	switch args[0] {
-	default:
-		log.Fatalln("Usage: go main.go server|dedup|check")
 	case "server":
-		wh := webdav.Handler{
-			FileSystem: vfs.NewVFS(st), // os.FS cannot be used here :(
-			LockSystem: webdav.NewMemLS(),
-			Logger: func(r *http.Request, e error) {
-				log.Printf("%s %s ERR:%s", r.Method, r.URL, e)
-			},
-		}
-		listener, err := net.Listen("tcp", ":8082")
+		lock, err := admin.Acquire(conf.Root)
 		if err != nil {
 			log.Fatal(err)
 		}
+		defer lock.Release()
+		server(conf)
+	case "dedup", "similar", "search", "tag", "prune", "check":
+		req := admin.Request{User: user, Collection: collection, Args: args}
+		if args[0] == "dedup" {
+			// Choices are made here, the command runs wherever it may
+			if req, err = promptDedup(conf, req, os.Stdin, os.Stdout); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err := run(conf, req); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatalln("Usage: go main.go server|dedup|similar|check|search|tag|prune|config|hash-password|app-password|token")
+	}
+}
+
+// run runs a collection command in this process when no server holds the
+// data root, or has the server run it over its admin socket.
+func run(conf *config.Config, req admin.Request) error {
+	lock, err := admin.Acquire(conf.Root)
+	if errors.Is(err, admin.ErrLocked) {
+		// Likely a server, otherwise another command
+		rerr := admin.Run(context.Background(), adminSocket(conf), req, os.Stdout)
+		if errors.Is(rerr, admin.ErrUnreachable) {
+			return fmt.Errorf("%s, try again later: %w", err, rerr)
+		}
+		return rerr
+	}
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	lib, err := userCollections(conf, req.User)
+	if err != nil {
+		return err
+	}
+	defer lib.Close()
+	return command(context.Background(), conf, lib, req, os.Stdout)
+}
 
-		serve := func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
+// checkUser tells if username, empty for the data root, may be given to
+// commands.
+func checkUser(conf *config.Config, username string) error {
+	if username == "" {
+		return nil
+	}
+	users, err := configUsers(conf)
+	if err != nil {
+		return err
+	}
+	if _, ok := users[username]; !ok {
+		return fmt.Errorf("unknown user %q, pick one with -user", username)
+	}
+	return nil
+}
 
-			// Gets the correct user for this request.
-			_, password, ok := r.BasicAuth()
+// userCollections opens the collections of username for a command, without
+// creating anything for users who never logged in.
+func userCollections(conf *config.Config, username string) (*store.Collections, error) {
+	if err := checkUser(conf, username); err != nil {
+		return nil, err
+	}
+	dir := userRoot(conf.Root, username)
+	if _, err := os.Stat(dir); err != nil {
+		return nil, fmt.Errorf("user %q has no bookmarks: %w", username, err)
+	}
+	return store.NewCollections(dir, guard(conf), urls(conf)), nil
+}
 
-			if !ok {
-				http.Error(w, "Not authorized", http.StatusUnauthorized)
-				return
+// command runs the collection command of req against lib, printing to w.
+func command(ctx context.Context, conf *config.Config, lib *store.Collections, req admin.Request, w io.Writer) error {
+	st, err := lib.Open(req.Collection, false)
+	if err != nil {
+		return fmt.Errorf("collection %s: %w", req.Collection, err)
+	}
+	args := req.Args
+	// Flag errors and usage go to w, as does everything else
+	flags := func(name string) *flag.FlagSet {
+		fs := flag.NewFlagSet(name, flag.ContinueOnError)
+		fs.SetOutput(w)
+		return fs
+	}
+
+	switch args[0] {
+	default:
+		return fmt.Errorf("unknown command %s", args[0])
+
+	case "dedup":
+		return dedupCommand(ctx, conf, st, flags("dedup"), args[1:], w)
+
+	case "similar":
+		return similarCommand(ctx, conf, st, flags("similar"), args[1:], w)
+
+	case "search":
+		fs := flags("search")
+		history := fs.Bool("a", false, "search all revisions, not only the latest")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		hits := st.Search(strings.Join(fs.Args(), " "), *history)
+		for _, h := range hits {
+			mark := " "
+			if !h.InHead {
+				mark = "-"
+			}
+			fmt.Fprintf(w, "%s %s %s\n", mark, h.Title, h.Href)
+			if h.Desc != "" {
+				fmt.Fprintf(w, "    %s\n", h.Desc)
 			}
+			fmt.Fprintf(w, "    in %s\n", strings.Join(h.Revisions, " "))
+		}
+
+	case "tag":
+		fs := flags("tag")
+		del := fs.Bool("d", false, "delete the tag")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
 
-			if !checkPassword(secret, password) {
-				http.Error(w, "Not authorized", http.StatusUnauthorized)
-				return
+		switch {
+		case fs.NArg() == 0:
+			tags := st.Tags()
+			names := make([]string, 0, len(tags))
+			for n := range tags {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			for _, n := range names {
+				fmt.Fprintf(w, "%s %s\n", n, tags[n])
 			}
+		case *del:
+			return st.Untag(fs.Arg(0))
+		default:
+			return st.Tag(fs.Arg(0), fs.Arg(1))
+		}
 
-			if r.URL.Path == "/info" {
-				st.ServeHTTP(w, r)
-			} else {
-				wh.ServeHTTP(w, r)
+	case "prune":
+		r := store.Retention{Keep: conf.Retention.Keep, MaxAge: conf.Retention.MaxAge.Duration}
+		for name, s := range lib.All() {
+			removed, err := s.Prune(r)
+			for _, id := range removed {
+				fmt.Fprintf(w, "%s: removed %s\n", name, id)
+			}
+			if err != nil {
+				return err
 			}
 		}
 
-		log.Printf("Serving on %s", listener.Addr())
-		if err := http.Serve(listener, Server(serve)); err != nil {
-			log.Print("shutting server", err)
+	case "check":
+		f := fixes(conf)
+		fs := flags("check")
+		fs.StringVar(&f.Dead, "dead", f.Dead, "what to do with dead links: prefix, move or keep")
+		fs.BoolVar(&f.Redirects, "redirects", f.Redirects, "replace permanently redirected links")
+		dryRun := fs.Bool("n", false, "show what would change without recording a revision")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if f.Dead == "keep" {
+			f.Dead = store.DeadKeep
 		}
 
-	case "dedup":
-		buf, _ := st.Get()
-		x, err := xbel.Parse(buf)
+		printf := func(format string, a ...interface{}) {
+			fmt.Fprintf(w, format+"\n", a...)
+		}
+		if err := checkLinks(ctx, newChecker(conf), st, conf.LinkCheck.DeadAfter, printf); err != nil {
+			return err
+		}
+		if f.Dead == store.DeadKeep && !f.Redirects {
+			return nil
+		}
+		changes, err := st.FixLinks(ctx, f, *dryRun)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
+		for _, c := range changes {
+			fmt.Fprintln(w, c)
+		}
+		switch {
+		case len(changes) == 0:
+			fmt.Fprintln(w, "Nothing to fix")
+		case *dryRun:
+			fmt.Fprintf(w, "%d bookmark(s) would change\n", len(changes))
+		default:
+			fmt.Fprintf(w, "Recorded %s\n", st.Head())
+		}
+	}
+	return nil
+}
 
-		hrefs := make(map[string]struct{})
-		nx := xbel.Walk(x, func(b *xbel.Bookmark) bool {
-			if _, exists := hrefs[b.Href]; exists {
-				log.Println("Duplicate " + b.Href)
-				return false
-			}
+// adminSocket is where the server takes commands.
+func adminSocket(conf *config.Config) string {
+	if conf.AdminSocket != "" {
+		return conf.AdminSocket
+	}
+	return filepath.Join(conf.Root, admin.SocketFile)
+}
 
-			hrefs[b.Href] = struct{}{}
+// fixes returns how check outcomes are written back, as configured.
+func fixes(conf *config.Config) store.Fixes {
+	return store.Fixes{
+		Dead:      conf.LinkCheck.Fix.Dead,
+		Prefix:    conf.LinkCheck.Fix.Prefix,
+		Folder:    conf.LinkCheck.Fix.Folder,
+		Redirects: conf.LinkCheck.Fix.Redirects,
+		DeadAfter: conf.LinkCheck.DeadAfter,
+	}
+}
 
-			return true
-		})
+// newChecker returns a link checker set up from conf.
+func newChecker(conf *config.Config) *checker.Checker {
+	c := checker.New()
+	c.Timeout = conf.LinkCheck.Timeout.Duration
+	c.Concurrency = conf.LinkCheck.Concurrency
+	c.PerHost = conf.LinkCheck.PerHost
+	c.Retries = conf.LinkCheck.Retries
+	c.UserAgent = conf.LinkCheck.UserAgent
+	c.Probe = conf.LinkCheck.Probe
+	c.Parked = conf.LinkCheck.Parked
+	c.URLs = urls(conf)
+	for _, r := range conf.LinkCheck.Rules {
+		// Validated with the configuration
+		c.AddRule(checker.Rule{Domain: r.Domain, URL: r.URL, Body: r.Body, Title: r.Title, Homepage: r.Homepage})
+	}
+	return c
+}
+
+// checkLinks checks bookmarks of the head revision of st, recording results
+// in its link health. Links not plainly working are printed with their state,
+// then a count of each outcome.
+func checkLinks(ctx context.Context, c *checker.Checker, st *store.Store, deadAfter int, printf func(string, ...interface{})) error {
+	db, err := st.Links()
+	if err != nil {
+		return err
+	}
+	var hrefs []string
+	seen := make(map[string]bool)
+	for _, b := range st.Bookmarks() {
+		if !seen[b.Href] {
+			seen[b.Href] = true
+			hrefs = append(hrefs, b.Href)
+		}
+	}
 
-		b := bytes.NewBuffer([]byte{})
-		xbel.Write(b, nx)
+	results := c.CheckAll(ctx, hrefs)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := db.Record(results, time.Now()); err != nil {
+		return err
+	}
 
-		err = st.Set(b.Bytes())
+	counts := make(map[checker.Status]int)
+	for _, r := range results {
+		counts[r.Status]++
+		h, _ := db.Get(r.URL)
+		state := h.State(deadAfter)
+		switch {
+		case r.Status == checker.OK || r.Status == checker.Skipped:
+		case r.Status == checker.Redirected:
+			printf("%-10s %-10s %s -> %s", state, r.Status, r.URL, r.FinalURL)
+		case r.Err != nil:
+			printf("%-10s %-10s %s (%s)", state, r.Status, r.URL, r.Err)
+		default:
+			printf("%-10s %-10s %s (%d)", state, r.Status, r.URL, r.Code)
+		}
+	}
+	for _, s := range []checker.Status{checker.OK, checker.Redirected, checker.ClientError, checker.ServerError,
+		checker.SoftNotFound, checker.Parked, checker.DNSFailure, checker.TLSError, checker.Timeout,
+		checker.Failed, checker.Skipped} {
+		if counts[s] > 0 {
+			printf("%s: %d", s, counts[s])
+		}
+	}
+	return nil
+}
+
+// hashPassword prints the hash of a password read from stdin, for the
+// configuration or users file.
+func hashPassword(args []string) {
+	fs := flag.NewFlagSet("hash-password", flag.ExitOnError)
+	scheme := fs.String("scheme", auth.Schemes[0], "one of "+strings.Join(auth.Schemes, ", "))
+	fs.Parse(args)
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	sc := bufio.NewScanner(os.Stdin)
+	if !sc.Scan() {
+		log.Fatal("no password given")
+	}
+	password := strings.TrimRight(sc.Text(), "\r")
+	if password == "" {
+		log.Fatal("no password given")
+	}
+	h, err := auth.Hash(*scheme, password)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(h)
+}
+
+// appPassword manages app passwords: add prints a new one, list shows them
+// and revoke removes one.
+func appPassword(ap *auth.AppPasswords, args []string) {
+	if len(args) == 0 {
+		log.Fatalln("Usage: go main.go app-password add|list|revoke [label]")
+	}
+	switch args[0] {
+	case "add", "revoke":
+		if len(args) != 2 {
+			log.Fatalf("Usage: go main.go app-password %s label", args[0])
+		}
+		if args[0] == "revoke" {
+			if err := ap.Revoke(args[1]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+		password, err := ap.Add(args[1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(password)
+	case "list":
+		aps, err := ap.List()
 		if err != nil {
 			log.Fatal(err)
 		}
+		for _, a := range aps {
+			fmt.Printf("%s %s\n", a.Label, a.Created.Format("2006-01-02 15:04"))
+		}
+	default:
+		log.Fatalln("Usage: go main.go app-password add|list|revoke [label]")
+	}
+}
 
-	case "check":
-		buf, _ := st.Get()
-		x, err := xbel.Parse(buf)
+// token manages API tokens of user: add prints a new one with the given
+// scopes, list shows them and revoke removes one.
+func token(tokens *auth.Tokens, user string, args []string) {
+	usage := "Usage: go main.go token add label scope[,scope]|list|revoke label"
+	if len(args) == 0 {
+		log.Fatalln(usage)
+	}
+	switch {
+	case args[0] == "add" && len(args) == 3:
+		scopes, err := auth.ParseScopes(args[2])
+		if err != nil {
+			log.Fatal(err)
+		}
+		t, err := tokens.Add(user, args[1], scopes)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(t)
+	case args[0] == "list":
+		ts, err := tokens.List(user)
 		if err != nil {
 			log.Fatal(err)
 		}
+		for _, t := range ts {
+			fmt.Printf("%s %s %s\n", t.Label, strings.Join(t.Scopes, ","), t.Created.Format("2006-01-02 15:04"))
+		}
+	case args[0] == "revoke" && len(args) == 2:
+		if err := tokens.Revoke(user, args[1]); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatalln(usage)
+	}
+}
 
-		xbel.Walk(x, func(b *xbel.Bookmark) bool {
-			// Lets findout if still ok
-			// if false && strings.HasPrefix(b.Href, "https://example.com") {
-			fmt.Println("Checking " + b.Href)
-			resp, err := http.Get(b.Href)
-			// handle the error if there is one
-			if err != nil {
-				panic(err)
-			}
-			// do this now so it won't be forgotten
-			defer resp.Body.Close()
-			// reads html as a slice of bytes
-			html, err := ioutil.ReadAll(resp.Body)
-			if err != nil {
-				panic(err)
-			}
-			// show the HTML code as a string %s
-			if strings.Contains(string(html), "Post Not Found") {
-				fmt.Println("DEAD")
-				b.Title = "[DEAD] " + b.Title
-			}
-			return true
-		})
+// urls returns how links are compared, as configured.
+func urls(c *config.Config) *urlnorm.Normalizer {
+	return &urlnorm.Normalizer{
+		Scheme:        c.URLs.Scheme,
+		WWW:           c.URLs.WWW,
+		TrailingSlash: c.URLs.TrailingSlash,
+		DefaultPort:   c.URLs.DefaultPort,
+		Fragment:      c.URLs.Fragment,
+		SortQuery:     c.URLs.SortQuery,
+		Tracking:      c.URLs.Tracking,
 	}
 }
 
-type Server func(w http.ResponseWriter, r *http.Request)
+func guard(c *config.Config) store.Guard {
+	return store.Guard{
+		MaxRemoved:      c.Guards.MaxRemoved,
+		MaxRemovedRatio: c.Guards.MaxRemovedRatio,
+	}
+}
 
-func (s Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	s(w, r)
+// library adapts store.Collections to vfs.Library.
+type library struct {
+	*store.Collections
 }
 
-func checkPassword(saved, input string) bool {
-	if strings.HasPrefix(saved, "{bcrypt}") {
-		savedPassword := strings.TrimPrefix(saved, "{bcrypt}")
-		return bcrypt.CompareHashAndPassword([]byte(savedPassword), []byte(input)) == nil
+func (l library) Open(name string, create bool) (vfs.Store, error) {
+	st, err := l.Collections.Open(name, create)
+	if err != nil {
+		return nil, err
 	}
-
-	return saved == input
+	return st, nil
 }
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/dav-m85/xbellum/config"
+	"github.com/matryer/is"
+)
+
+func TestUserCollections(t *testing.T) {
+	is := is.New(t)
+	conf := &config.Config{Root: t.TempDir(), Users: map[string]config.User{"alice": {Password: "x"}}}
+
+	lib, err := userCollections(conf, "")
+	is.NoErr(err)
+	lib.Close()
+
+	// Unknown users are refused, known ones need data
+	_, err = userCollections(conf, "bob")
+	is.True(err != nil)
+	_, err = userCollections(conf, "alice")
+	is.True(err != nil)
+
+	is.NoErr(os.MkdirAll(userRoot(conf.Root, "alice"), 0777))
+	lib, err = userCollections(conf, "alice")
+	is.NoErr(err)
+	lib.Close()
+
+	// Without users, only the data root
+	conf.Users = nil
+	_, err = userCollections(conf, "alice")
+	is.True(err != nil)
+}
@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	requests = Default.NewCounter("xbellum_http_requests_total",
+		"HTTP requests by method and status code.", "method", "code")
+	durations = Default.NewHistogram("xbellum_http_request_duration_seconds",
+		"HTTP request latencies by method.",
+		[]float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}, "method")
+)
+
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Handler counts and times requests to next by method, WebDAV ones
+// included.
+func Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		// Methods are free text, keep known ones only
+		method := r.Method
+		switch method {
+		case "GET", "HEAD", "POST", "PUT", "DELETE", "OPTIONS", "PROPFIND", "PROPPATCH",
+			"MKCOL", "COPY", "MOVE", "LOCK", "UNLOCK":
+		default:
+			method = "other"
+		}
+		next.ServeHTTP(sw, r)
+		if sw.code == 0 {
+			sw.code = http.StatusOK
+		}
+		requests.Inc(method, strconv.Itoa(sw.code))
+		durations.Since(start, method)
+	})
+}
@@ -0,0 +1,251 @@
+// Package metrics keeps counters and histograms, served in the Prometheus
+// text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sample is a value with label values, as returned by gauge functions.
+type Sample struct {
+	Labels []string
+	Value  float64
+}
+
+type metric interface {
+	write(w io.Writer)
+}
+
+// Registry holds metrics, written in registration order.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Default is the registry metrics of this module go to.
+var Default = NewRegistry()
+
+func (r *Registry) add(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// ServeHTTP writes all metrics.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+type desc struct {
+	name, help string
+	labels     []string
+}
+
+func (d desc) header(w io.Writer, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, d.help, d.name, kind)
+}
+
+// series formats name{labels}, extra being appended label name and value
+// pairs.
+func (d desc) series(name string, values []string, extra ...string) string {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(values)+len(extra) == 0 {
+		return b.String()
+	}
+	b.WriteByte('{')
+	for i, v := range values {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", d.labels[i], escape(v))
+	}
+	for i := 0; i < len(extra); i += 2 {
+		if i > 0 || len(values) > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", extra[i], escape(extra[i+1]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(v string) string {
+	return escaper.Replace(v)
+}
+
+func (d desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d labels, got %d", d.name, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Counter is a value going up, for each combination of label values.
+type Counter struct {
+	desc
+	mu     sync.Mutex
+	values map[string]*Sample
+}
+
+// NewCounter registers a counter in r.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{desc: desc{name, help, labels}, values: make(map[string]*Sample)}
+	r.add(c)
+	return c
+}
+
+// Add adds v to the counter with label values.
+func (c *Counter) Add(v float64, values ...string) {
+	k := c.key(values)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.values[k]
+	if !ok {
+		s = &Sample{Labels: append([]string(nil), values...)}
+		c.values[k] = s
+	}
+	s.Value += v
+}
+
+// Inc adds one to the counter with label values.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.header(w, "counter")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, k := range sortedKeys(c.values) {
+		s := c.values[k]
+		fmt.Fprintf(w, "%s %s\n", c.series(c.name, s.Labels), formatFloat(s.Value))
+	}
+}
+
+func sortedKeys(m map[string]*Sample) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Histogram counts observations in buckets, for each combination of label
+// values.
+type Histogram struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	values  map[string]*histogram
+}
+
+type histogram struct {
+	labels []string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram registers a histogram in r, buckets being sorted upper
+// bounds.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{desc: desc{name, help, labels}, buckets: buckets, values: make(map[string]*histogram)}
+	r.add(h)
+	return h
+}
+
+// Observe records v in the histogram with label values.
+func (h *Histogram) Observe(v float64, values ...string) {
+	k := h.key(values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.values[k]
+	if !ok {
+		s = &histogram{labels: append([]string(nil), values...), counts: make([]uint64, len(h.buckets))}
+		h.values[k] = s
+	}
+	for i, b := range h.buckets {
+		if v <= b {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+// Since observes the seconds elapsed since start.
+func (h *Histogram) Since(start time.Time, values ...string) {
+	h.Observe(time.Since(start).Seconds(), values...)
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.header(w, "histogram")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	keys := make([]string, 0, len(h.values))
+	for k := range h.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := h.values[k]
+		for i, b := range h.buckets {
+			fmt.Fprintf(w, "%s %d\n", h.series(h.name+"_bucket", s.labels, "le", formatFloat(b)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s %d\n", h.series(h.name+"_bucket", s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s %s\n", h.series(h.name+"_sum", s.labels), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s %d\n", h.series(h.name+"_count", s.labels), s.count)
+	}
+}
+
+// Gauge is a value computed when metrics are collected.
+type Gauge struct {
+	desc
+	f func() []Sample
+}
+
+// NewGauge registers a gauge in r, f returning its values when collected.
+func (r *Registry) NewGauge(name, help string, f func() []Sample, labels ...string) *Gauge {
+	g := &Gauge{desc: desc{name, help, labels}, f: f}
+	r.add(g)
+	return g
+}
+
+func (g *Gauge) write(w io.Writer) {
+	g.header(w, "gauge")
+	for _, s := range g.f() {
+		fmt.Fprintf(w, "%s %s\n", g.series(g.name, s.Labels), formatFloat(s.Value))
+	}
+}
@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestRegistry(t *testing.T) {
+	is := is.New(t)
+
+	r := NewRegistry()
+	c := r.NewCounter("test_total", "Things.", "kind")
+	c.Inc("a")
+	c.Add(2, `b"`)
+	c.Inc("a")
+	h := r.NewHistogram("test_bytes", "Sizes.", []float64{10, 100})
+	h.Observe(5)
+	h.Observe(50)
+	h.Observe(500)
+	r.NewGauge("test_items", "Items.", func() []Sample {
+		return []Sample{{Labels: []string{"x"}, Value: 3}}
+	}, "name")
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	want := `# HELP test_total Things.
+# TYPE test_total counter
+test_total{kind="a"} 2
+test_total{kind="b\""} 2
+# HELP test_bytes Sizes.
+# TYPE test_bytes histogram
+test_bytes_bucket{le="10"} 1
+test_bytes_bucket{le="100"} 2
+test_bytes_bucket{le="+Inf"} 3
+test_bytes_sum 555
+test_bytes_count 3
+# HELP test_items Items.
+# TYPE test_items gauge
+test_items{name="x"} 3
+`
+	is.Equal(w.Body.String(), want)
+	is.True(strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain"))
+}
+
+func TestHandler(t *testing.T) {
+	is := is.New(t)
+
+	old := requests
+	defer func() { requests = old }()
+	requests = NewRegistry().NewCounter("requests_total", "", "method", "code")
+
+	h := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PUT" {
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	for _, m := range []string{"PROPFIND", "PUT", "BREW"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(m, "/", nil))
+	}
+	w := httptest.NewRecorder()
+	requests.write(w)
+	is.True(strings.Contains(w.Body.String(), `requests_total{method="PROPFIND",code="200"} 1`))
+	is.True(strings.Contains(w.Body.String(), `requests_total{method="PUT",code="201"} 1`))
+	is.True(strings.Contains(w.Body.String(), `requests_total{method="other",code="200"} 1`))
+}
@@ -0,0 +1,181 @@
+// Package proxy reads what a trusted reverse proxy tells about requests: the
+// client address, whether it used HTTPS, the path prefix the server is
+// mounted under, and optionally the user it authenticated.
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Proxy trusts X-Forwarded-* headers from some networks only, as anybody
+// else could forge them.
+type Proxy struct {
+	trusted []*net.IPNet
+	// UserHeader names the header carrying the username authenticated by the
+	// proxy, like X-Forwarded-User. Users aren't taken from proxies when
+	// empty.
+	UserHeader string
+}
+
+// New returns a proxy trusting cidrs, which can also be plain addresses.
+func New(cidrs []string, userHeader string) (*Proxy, error) {
+	p := &Proxy{UserHeader: userHeader}
+	for _, c := range cidrs {
+		n, err := ParseCIDR(c)
+		if err != nil {
+			return nil, err
+		}
+		p.trusted = append(p.trusted, n)
+	}
+	return p, nil
+}
+
+// ParseCIDR reads a network like 10.0.0.0/8, or a single address.
+func ParseCIDR(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid address %q", s)
+		}
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid network %q", s)
+	}
+	return n, nil
+}
+
+func (p *Proxy) trusts(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range p.trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwarded is what is known of a request, put in its context by Handler.
+type forwarded struct {
+	clientIP string
+	secure   bool
+	prefix   string
+	user     string
+	hasUser  bool
+}
+
+type ctxKey int
+
+const forwardedKey ctxKey = 0
+
+// Handler reads headers of requests coming from trusted proxies, making
+// them available to next with ClientIP, Secure, Prefix and User. Proxies
+// are expected to strip X-Forwarded-Prefix from the path they pass.
+func (p *Proxy) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f := forwarded{clientIP: remoteAddr(r), secure: r.TLS != nil}
+		if p.trusts(f.clientIP) {
+			f.clientIP = p.clientIP(r, f.clientIP)
+			switch strings.ToLower(r.Header.Get("X-Forwarded-Proto")) {
+			case "https":
+				f.secure = true
+			case "http":
+				f.secure = false
+			}
+			f.prefix = cleanPrefix(r.Header.Get("X-Forwarded-Prefix"))
+			if p.UserHeader != "" {
+				f.user = r.Header.Get(p.UserHeader)
+				f.hasUser = f.user != ""
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), forwardedKey, f)))
+	})
+}
+
+// clientIP walks X-Forwarded-For from the right, proxies appending the
+// address they got the request from, up to the first untrusted address.
+func (p *Proxy) clientIP(r *http.Request, peer string) string {
+	var hops []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		for _, a := range strings.Split(h, ",") {
+			hops = append(hops, strings.TrimSpace(a))
+		}
+	}
+	ip := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		if net.ParseIP(hops[i]) == nil {
+			break
+		}
+		ip = hops[i]
+		if !p.trusts(ip) {
+			break
+		}
+	}
+	return ip
+}
+
+func cleanPrefix(s string) string {
+	if s == "" {
+		return ""
+	}
+	s = path.Clean("/" + s)
+	if s == "/" {
+		return ""
+	}
+	return s
+}
+
+func remoteAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func from(r *http.Request) (forwarded, bool) {
+	f, ok := r.Context().Value(forwardedKey).(forwarded)
+	return f, ok
+}
+
+// ClientIP returns the address of the client, without port.
+func ClientIP(r *http.Request) string {
+	if f, ok := from(r); ok {
+		return f.clientIP
+	}
+	return remoteAddr(r)
+}
+
+// Secure tells if the client uses HTTPS.
+func Secure(r *http.Request) bool {
+	if f, ok := from(r); ok {
+		return f.secure
+	}
+	return r.TLS != nil
+}
+
+// Prefix returns the path the server is mounted under, like /bookmarks, to
+// put in front of links. It is empty when mounted at the root.
+func Prefix(r *http.Request) string {
+	f, _ := from(r)
+	return f.prefix
+}
+
+// User returns the username authenticated by a trusted proxy, if any.
+func User(r *http.Request) (string, bool) {
+	f, _ := from(r)
+	return f.user, f.hasUser
+}
@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestProxy(t *testing.T) {
+	is := is.New(t)
+
+	_, err := New([]string{"10.0.0.0/33"}, "")
+	is.True(err != nil)
+	p, err := New([]string{"10.0.0.0/8", "::1"}, "X-Forwarded-User")
+	is.NoErr(err)
+
+	var got *http.Request
+	h := p.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { got = r }))
+	do := func(remote string, headers ...string) {
+		r := httptest.NewRequest("GET", "/info", nil)
+		r.RemoteAddr = remote
+		for i := 0; i < len(headers); i += 2 {
+			r.Header.Add(headers[i], headers[i+1])
+		}
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	forwarded := []string{
+		"X-Forwarded-For", "203.0.113.7, 10.1.1.1",
+		"X-Forwarded-Proto", "https",
+		"X-Forwarded-Prefix", "/bookmarks/",
+		"X-Forwarded-User", "alice",
+	}
+
+	// Trusted proxies are believed
+	do("10.0.0.1:4242", forwarded...)
+	is.Equal(ClientIP(got), "203.0.113.7")
+	is.True(Secure(got))
+	is.Equal(Prefix(got), "/bookmarks")
+	user, ok := User(got)
+	is.True(ok)
+	is.Equal(user, "alice")
+
+	do("[::1]:4242", "X-Forwarded-Prefix", "/")
+	is.Equal(ClientIP(got), "::1")
+	is.Equal(Prefix(got), "")
+
+	// Others are not
+	do("192.0.2.1:4242", forwarded...)
+	is.Equal(ClientIP(got), "192.0.2.1")
+	is.True(!Secure(got))
+	is.Equal(Prefix(got), "")
+	_, ok = User(got)
+	is.True(!ok)
+
+	// Nor are addresses they put in X-Forwarded-For
+	do("10.0.0.1:4242", "X-Forwarded-For", "10.9.9.9, 192.0.2.1")
+	is.Equal(ClientIP(got), "192.0.2.1")
+}
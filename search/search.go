@@ -0,0 +1,153 @@
+package search
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/dav-m85/xbellum/urlnorm"
+	"github.com/dav-m85/xbellum/xbel"
+)
+
+// Index is an inverted index over the title, href and description of every
+// bookmark of every revision it has been fed. A bookmark that stays the same
+// across revisions is indexed once, and remembers which revisions had it.
+type Index struct {
+	urls      *urlnorm.Normalizer
+	mu        sync.RWMutex
+	revisions []string
+	docs      []*doc
+	byKey     map[string]*doc
+	terms     map[string][]*doc
+}
+
+type doc struct {
+	title, href, desc string
+	// revisions holds indexes into Index.revisions, in ascending order.
+	revisions []int
+}
+
+// Hit is a bookmark matching a query.
+type Hit struct {
+	Title     string
+	Href      string
+	Desc      string
+	Revisions []string
+	// InHead is true when the latest indexed revision contains the bookmark.
+	InHead bool
+}
+
+// New returns an empty index, links being compared by their key with urls.
+func New(urls *urlnorm.Normalizer) *Index {
+	return &Index{
+		urls:  urls,
+		byKey: make(map[string]*doc),
+		terms: make(map[string][]*doc),
+	}
+}
+
+// Add indexes all bookmarks of x as being part of revision. Revisions are
+// expected to be added in order, the last one being the head.
+func (i *Index) Add(revision string, x *xbel.XBEL) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	rev := len(i.revisions)
+	i.revisions = append(i.revisions, revision)
+
+	xbel.Walk(x, func(b *xbel.Bookmark) bool {
+		// Variants of a link are one bookmark
+		key := i.urls.Key(b.Href) + "\x00" + b.Title + "\x00" + b.Desc
+		d, ok := i.byKey[key]
+		if !ok {
+			d = &doc{title: b.Title, href: b.Href, desc: b.Desc}
+			i.byKey[key] = d
+			i.docs = append(i.docs, d)
+			seen := make(map[string]struct{})
+			for _, t := range tokenize(b.Title + " " + b.Href + " " + b.Desc) {
+				if _, dup := seen[t]; dup {
+					continue
+				}
+				seen[t] = struct{}{}
+				i.terms[t] = append(i.terms[t], d)
+			}
+		}
+		// Same bookmark twice in one revision
+		if n := len(d.revisions); n == 0 || d.revisions[n-1] != rev {
+			d.revisions = append(d.revisions, rev)
+		}
+		return true
+	})
+}
+
+// Search returns bookmarks matching every word of query, a word matching
+// any indexed term it prefixes. Unless history is set, only bookmarks of the
+// head revision are returned.
+func (i *Index) Search(query string, history bool) []Hit {
+	words := tokenize(query)
+	if len(words) == 0 {
+		return nil
+	}
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	head := len(i.revisions) - 1
+	var matches map[*doc]struct{}
+	for _, w := range words {
+		found := make(map[*doc]struct{})
+		for t, docs := range i.terms {
+			if !strings.HasPrefix(t, w) {
+				continue
+			}
+			for _, d := range docs {
+				if matches == nil {
+					found[d] = struct{}{}
+				} else if _, ok := matches[d]; ok {
+					found[d] = struct{}{}
+				}
+			}
+		}
+		matches = found
+		if len(matches) == 0 {
+			return nil
+		}
+	}
+
+	var hits []Hit
+	for d := range matches {
+		inHead := d.revisions[len(d.revisions)-1] == head
+		if !history && !inHead {
+			continue
+		}
+		revs := make([]string, len(d.revisions))
+		for k, r := range d.revisions {
+			revs[k] = i.revisions[r]
+		}
+		hits = append(hits, Hit{
+			Title:     d.title,
+			Href:      d.href,
+			Desc:      d.desc,
+			Revisions: revs,
+			InHead:    inHead,
+		})
+	}
+	sort.Slice(hits, func(a, b int) bool {
+		if hits[a].InHead != hits[b].InHead {
+			return hits[a].InHead
+		}
+		if hits[a].Href != hits[b].Href {
+			return hits[a].Href < hits[b].Href
+		}
+		return hits[a].Title < hits[b].Title
+	})
+	return hits
+}
+
+// tokenize lowercases s and splits it on anything not a letter or a digit.
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
@@ -0,0 +1,48 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/dav-m85/xbellum/urlnorm"
+	"github.com/dav-m85/xbellum/xbel"
+	"github.com/matryer/is"
+)
+
+func folder(bs ...xbel.Bookmark) *xbel.XBEL {
+	return &xbel.XBEL{
+		Version: xbel.SUPPORTED_VERSION,
+		Folders: []xbel.Folder{{Title: "root", Bookmarks: bs}},
+	}
+}
+
+func TestSearch(t *testing.T) {
+	is := is.New(t)
+
+	idx := New(urlnorm.New())
+	idx.Add("bkm_000000.xbel", folder(
+		xbel.Bookmark{Title: "Go spec", Href: "https://golang.org/ref/spec"},
+		xbel.Bookmark{Title: "Rust book", Href: "https://doc.rust-lang.org/book/"},
+	))
+	idx.Add("bkm_000001.xbel", folder(
+		xbel.Bookmark{Title: "Go spec", Href: "https://golang.org/ref/spec"},
+		xbel.Bookmark{Title: "Gardening", Href: "https://example.com", Desc: "tomatoes and such"},
+	))
+
+	hits := idx.Search("go", false)
+	is.Equal(len(hits), 1)
+	is.Equal(hits[0].Href, "https://golang.org/ref/spec")
+	is.Equal(hits[0].Revisions, []string{"bkm_000000.xbel", "bkm_000001.xbel"})
+	is.True(hits[0].InHead)
+
+	// Rust is gone from head
+	is.Equal(len(idx.Search("rust", false)), 0)
+	hits = idx.Search("RUST book", true)
+	is.Equal(len(hits), 1)
+	is.Equal(hits[0].Revisions, []string{"bkm_000000.xbel"})
+	is.True(!hits[0].InHead)
+
+	// Descriptions are searched, every word must match
+	is.Equal(len(idx.Search("tomato", false)), 1)
+	is.Equal(len(idx.Search("tomato rust", true)), 0)
+	is.Equal(len(idx.Search("  ", true)), 0)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/dav-m85/xbellum/admin"
+	"github.com/dav-m85/xbellum/certs"
+	"github.com/dav-m85/xbellum/config"
+	"github.com/dav-m85/xbellum/logging"
+	"github.com/dav-m85/xbellum/metrics"
+	"github.com/dav-m85/xbellum/proxy"
+	"github.com/dav-m85/xbellum/store"
+	dav "github.com/dav-m85/xbellum/webdav"
+	"go.uber.org/zap"
+)
+
+type Server func(w http.ResponseWriter, r *http.Request)
+
+func (s Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s(w, r)
+}
+
+// server serves WebDAV and the web UI until the listener fails.
+func server(conf *config.Config) {
+	// Without users, everyone shares the data root with the secret
+	users, err := configUsers(conf)
+	if err != nil {
+		zap.L().Fatal("loading users failed", zap.Error(err))
+	}
+	if users != nil {
+		zap.L().Info("users loaded", zap.Int("count", len(users)))
+	}
+	tn := newTenants(conf)
+	cfg, err := davConfig(tn, users, conf)
+	if err != nil {
+		zap.L().Fatal("setting up authentication failed", zap.Error(err))
+	}
+
+	names := make([]string, 0, len(users))
+	for n := range users {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	sched := newScheduler(conf, tn, names)
+	sched.CSRF = func(r *http.Request) string {
+		return dav.CSRFToken(r.Context())
+	}
+
+	// The command line runs collection commands through the server while it
+	// holds the data root
+	adm, err := admin.Listen(adminSocket(conf), func(ctx context.Context, req admin.Request, w io.Writer) error {
+		if err := checkUser(conf, req.User); err != nil {
+			return err
+		}
+		t, err := tn.get(req.User)
+		if err != nil {
+			return err
+		}
+		return command(ctx, conf, t.lib, req, w)
+	})
+	if err != nil {
+		zap.L().Fatal("listening on admin socket failed", zap.Error(err))
+	}
+
+	listener, err := net.Listen("tcp", conf.Listen)
+	if err != nil {
+		zap.L().Fatal("listening failed", zap.Error(err))
+	}
+
+	ui := cfg.UI(Server(func(w http.ResponseWriter, r *http.Request) {
+		u := dav.UserFrom(r.Context())
+		if r.URL.Path == "/info/tokens" {
+			cfg.ServeTokens(w, r)
+			return
+		}
+		if r.URL.Path == "/info/audit" {
+			if !u.Is(dav.RoleAdmin) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			cfg.Audit.ServeHTTP(w, r)
+			return
+		}
+		if r.URL.Path == "/info/jobs" {
+			if !u.Is(dav.RoleAdmin) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			sched.ServeHTTP(w, r)
+			return
+		}
+		t, err := tn.get(u.Username)
+		if err != nil {
+			logging.From(r.Context()).Error("opening collections failed", zap.Error(err))
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		t.lib.ServeHTTP(w, r)
+	}))
+	webdav := cfg.Handler(http.HandlerFunc(dav.ServeDAV))
+
+	h := &health{root: conf.Root}
+	registerGauges(tn)
+	// Metrics tell about users, only admins see them here
+	metricsHandler := cfg.Handler(Server(func(w http.ResponseWriter, r *http.Request) {
+		if !dav.UserFrom(r.Context()).Is(dav.RoleAdmin) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		metrics.Default.ServeHTTP(w, r)
+	}))
+	if conf.Metrics.Listen != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Default)
+		mux.HandleFunc("/healthz", h.live)
+		mux.HandleFunc("/readyz", h.ready)
+		go func() {
+			zap.L().Info("serving metrics", zap.String("address", conf.Metrics.Listen))
+			zap.L().Fatal("serving metrics failed", zap.Error(http.ListenAndServe(conf.Metrics.Listen, mux)))
+		}()
+	}
+
+	var handler http.Handler = Server(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/healthz":
+			h.live(w, r)
+		case r.URL.Path == "/readyz":
+			h.ready(w, r)
+		case r.URL.Path == "/metrics":
+			metricsHandler.ServeHTTP(w, r)
+		case isUI(r.URL.Path):
+			ui.ServeHTTP(w, r)
+		default:
+			webdav.ServeHTTP(w, r)
+		}
+	})
+	handler = metrics.Handler(handler)
+	handler = logging.Handler(handler)
+	// Validated with the configuration
+	p, _ := proxy.New(conf.Proxy.Trusted, conf.Proxy.UserHeader)
+	handler = p.Handler(handler)
+
+	// Errors of net/http, like failed TLS handshakes
+	errorLog, _ := zap.NewStdLogAt(zap.L().Named("http"), zap.WarnLevel)
+	var redirect *http.Server
+	if conf.TLS.Cert != "" {
+		reloader, err := certs.NewReloader(conf.TLS.Cert, conf.TLS.Key)
+		if err != nil {
+			zap.L().Fatal("loading certificate failed", zap.Error(err))
+		}
+		listener = tls.NewListener(listener, reloader.TLSConfig())
+		if conf.TLS.HSTS.Duration > 0 {
+			handler = certs.HSTS(handler, conf.TLS.HSTS.Duration)
+		}
+		if conf.TLS.RedirectHTTP != "" {
+			redirect = &http.Server{Addr: conf.TLS.RedirectHTTP, Handler: certs.RedirectHTTPS(conf.Listen), ErrorLog: errorLog}
+			go func() {
+				zap.L().Info("redirecting to HTTPS", zap.String("address", conf.TLS.RedirectHTTP))
+				if err := redirect.ListenAndServe(); err != http.ErrServerClosed {
+					zap.L().Fatal("redirecting failed", zap.Error(err))
+				}
+			}()
+		}
+	}
+
+	srv := &http.Server{Handler: handler, ErrorLog: errorLog}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		zap.L().Info("shutting down", zap.Stringer("signal", <-sig))
+		signal.Stop(sig)
+		h.stop()
+
+		// Stop accepting connections and wait for requests in flight, like
+		// uploads being recorded
+		ctx, cancel := context.WithTimeout(context.Background(), conf.ShutdownTimeout.Duration)
+		defer cancel()
+		if redirect != nil {
+			redirect.Shutdown(ctx)
+		}
+		if err := srv.Shutdown(ctx); err != nil {
+			zap.L().Warn("requests still running", zap.Duration("after", conf.ShutdownTimeout.Duration), zap.Error(err))
+		}
+		if err := adm.Shutdown(ctx); err != nil {
+			zap.L().Warn("commands still running", zap.Duration("after", conf.ShutdownTimeout.Duration), zap.Error(err))
+		}
+	}()
+
+	sched.Start()
+	zap.L().Info("serving", zap.Stringer("address", listener.Addr()))
+	if err := srv.Serve(listener); err != http.ErrServerClosed {
+		zap.L().Fatal("serving failed", zap.Error(err))
+	}
+	<-stopped
+	// Running jobs are cancelled, their writes complete before closing
+	sched.Stop()
+	// Writes of requests cut by the timeout still complete
+	tn.close()
+	zap.L().Info("stopped")
+}
+
+// registerGauges exposes revision and bookmark counts of every collection.
+func registerGauges(tn *tenants) {
+	collect := func(f func(s *store.Store) int) func() []metrics.Sample {
+		return func() []metrics.Sample {
+			var samples []metrics.Sample
+			tn.each(func(username string, lib *store.Collections) {
+				for name, s := range lib.All() {
+					samples = append(samples, metrics.Sample{
+						Labels: []string{username, name},
+						Value:  float64(f(s)),
+					})
+				}
+			})
+			return samples
+		}
+	}
+	metrics.Default.NewGauge("xbellum_revisions", "Revisions of a collection.",
+		collect(func(s *store.Store) int { n, _ := s.Stats(); return n }), "user", "collection")
+	metrics.Default.NewGauge("xbellum_head_bookmarks", "Bookmarks in the latest revision of a collection.",
+		collect(func(s *store.Store) int { _, n := s.Stats(); return n }), "user", "collection")
+}
+
+// isUI tells if path belongs to the web UI rather than WebDAV.
+func isUI(path string) bool {
+	return path == "/info" || strings.HasPrefix(path, "/info/") ||
+		path == "/login" || path == "/logout"
+}
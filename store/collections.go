@@ -0,0 +1,138 @@
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/dav-m85/xbellum/dedup"
+	"github.com/dav-m85/xbellum/urlnorm"
+)
+
+// DefaultCollection is the collection floccus users start with. It lives
+// directly in the data root, so that stores predating collections keep their
+// history.
+const DefaultCollection = "bookmarks"
+
+const collectionsDir = "collections"
+
+var collectionReg = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ValidCollection tells if name can be used as a collection name.
+func ValidCollection(name string) bool {
+	return collectionReg.MatchString(name)
+}
+
+// Collections holds one Store per named bookmark collection, each with its
+// own revision history. Collections other than DefaultCollection are kept in
+// root/collections/<name>.
+type Collections struct {
+	mu     sync.Mutex
+	root   string
+	stores map[string]*Store
+
+	// Guard is given to every store.
+	Guard Guard
+	urls  *urlnorm.Normalizer
+
+	// Allowed tells if the web UI request may read collection, or modify it
+	// when write is set. Everything is allowed when nil.
+	Allowed func(r *http.Request, collection string, write bool) bool
+
+	// CSRF returns the token web UI forms carry in a csrf field, if any.
+	CSRF func(r *http.Request) string
+
+	// Fixes are offered on the link health page, its DeadAfter telling dead
+	// links.
+	Fixes Fixes
+
+	// Dedup is the policy the duplicates page starts with.
+	Dedup dedup.Policy
+}
+
+// NewCollections loads the collections in root, links being compared by
+// their key with urls.
+func NewCollections(root string, guard Guard, urls *urlnorm.Normalizer) *Collections {
+	c := &Collections{
+		root:   root,
+		stores: make(map[string]*Store),
+		Guard:  guard,
+		urls:   urls,
+	}
+	c.stores[DefaultCollection] = NewStore(root, urls)
+
+	fs, err := ioutil.ReadDir(filepath.Join(root, collectionsDir))
+	if err != nil && !os.IsNotExist(err) {
+		panic(err)
+	}
+	for _, f := range fs {
+		if f.IsDir() && ValidCollection(f.Name()) {
+			c.stores[f.Name()] = NewStore(filepath.Join(root, collectionsDir, f.Name()), urls)
+		}
+	}
+	for _, s := range c.stores {
+		s.Guard = guard
+	}
+	return c
+}
+
+// All returns every store, by collection name.
+func (c *Collections) All() map[string]*Store {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	all := make(map[string]*Store, len(c.stores))
+	for n, s := range c.stores {
+		all[n] = s
+	}
+	return all
+}
+
+// Close closes every store, waiting for writes in progress.
+func (c *Collections) Close() {
+	for _, s := range c.All() {
+		s.Close()
+	}
+}
+
+// Names lists collections having at least one revision, sorted.
+func (c *Collections) Names() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var names []string
+	for n, s := range c.stores {
+		if s.Head() != "" {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open returns the store of collection name. When create is set, a missing
+// collection is created.
+func (c *Collections) Open(name string, create bool) (*Store, error) {
+	if !ValidCollection(name) {
+		return nil, fmt.Errorf("invalid collection name %q", name)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s, ok := c.stores[name]; ok {
+		return s, nil
+	}
+	if !create {
+		return nil, os.ErrNotExist
+	}
+	dir := filepath.Join(c.root, collectionsDir, name)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	s := NewStore(dir, c.urls)
+	s.Guard = c.Guard
+	c.stores[name] = s
+	return s, nil
+}
@@ -0,0 +1,185 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/dav-m85/xbellum/dedup"
+	"github.com/dav-m85/xbellum/logging"
+	"github.com/dav-m85/xbellum/proxy"
+	"github.com/dav-m85/xbellum/xbel"
+	"go.uber.org/zap"
+)
+
+// Duplicates returns the head revision id and its groups of duplicates, the
+// survivor of each picked by p.
+func (s *Store) Duplicates(p dedup.Policy) (string, []dedup.Group) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	x := s.get()
+	if x == nil {
+		return "", nil
+	}
+	return s.head(), dedup.Find(x, p, s.urls)
+}
+
+// Dedup records the head revision without the duplicates groups drop, groups
+// coming from Duplicates on the head revision. It fails with ErrStale when
+// another revision was recorded since.
+func (s *Store) Dedup(ctx context.Context, head string, groups []dedup.Group, merge bool) error {
+	s.mu.RLock()
+	x := s.get()
+	current := s.head()
+	s.mu.RUnlock()
+	if x == nil || current != head {
+		return ErrStale
+	}
+	b := bytes.NewBuffer([]byte{})
+	xbel.Write(b, dedup.Apply(x, groups, merge))
+	// Checked again while recording, an upload may come in meanwhile
+	return s.SetIf(ctx, head, b.Bytes())
+}
+
+// Pick sets the survivor of groups from choices, mapping a group index to
+// the index of the entry to keep, -1 to keep them all.
+func Pick(groups []dedup.Group, choices map[int]int) error {
+	for g, e := range choices {
+		if g < 0 || g >= len(groups) || e < -1 || e >= len(groups[g].Entries) {
+			return fmt.Errorf("no entry %d in group %d", e, g)
+		}
+		groups[g].Keep = e
+	}
+	return nil
+}
+
+var duplicatesTpl = template.Must(template.New("duplicates").Parse(`
+<html>
+<body>
+<p><a href="{{.Prefix}}/info?c={{.Collection}}">Bookmarks</a></p>
+<h2>Duplicates in {{.Collection}}</h2>
+<form method="get">
+	<input type="hidden" name="c" value="{{.Collection}}">
+	Keep the
+	<select name="keep">
+		{{range .Keeps}}<option{{if eq . $.Policy.Keep}} selected{{end}}>{{.}}</option>{{end}}
+	</select>
+	one, preferred folder <input type="text" name="folder" value="{{.Policy.Folder}}" placeholder="Work/Reading">
+	<label><input type="checkbox" name="merge" value="1"{{if .Policy.Merge}} checked{{end}}> merge titles and descriptions</label>
+	<input type="submit" value="Apply">
+</form>
+{{if .Error}}<p style="color: red">{{.Error}}</p>{{end}}
+{{if not .Groups}}<p>No duplicates.</p>{{else}}
+<form method="post">
+	<input type="hidden" name="csrf" value="{{.CSRF}}">
+	<input type="hidden" name="c" value="{{.Collection}}">
+	<input type="hidden" name="head" value="{{.Head}}">
+	{{if .Policy.Merge}}<input type="hidden" name="merge" value="1">{{end}}
+	{{range $g, $group := .Groups}}
+	<h3>{{$group.Key}}</h3>
+	{{range $e, $entry := $group.Entries}}
+	<p><label><input type="radio" name="g{{$g}}" value="{{$e}}"{{if eq $e $group.Keep}} checked{{end}}>
+		<a href="{{.Href}}">{{.Title}}</a> in {{.Folder}}{{if .Added}}, added {{.Added}}{{end}}<br>
+		<small>{{.Href}}</small>{{if .Desc}}<br><small>{{.Desc}}</small>{{end}}</label></p>
+	{{end}}
+	<p><label><input type="radio" name="g{{$g}}" value="-1"> keep them all</label></p>
+	{{end}}
+	{{if .CanWrite}}<input type="submit" value="Record as a new revision">{{end}}
+</form>
+{{end}}
+{{if .Similar}}
+<h2>Similar bookmarks</h2>
+<p>Different links likely leading to the same content, to look into.</p>
+{{range .Similar}}
+<h3>{{printf "%.2f" .Score}}: {{.Why}}</h3>
+{{range .Entries}}<p><a href="{{.Href}}">{{.Title}}</a> in {{.Folder}}<br><small>{{.Href}}</small></p>{{end}}
+{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+// serveDuplicates lists duplicates of the head revision with the survivor
+// picked by the policy, which can be changed for each group before being
+// recorded.
+func (c *Collections) serveDuplicates(w http.ResponseWriter, r *http.Request, s *Store, name string) {
+	policy := c.Dedup
+	if r.FormValue("keep") != "" {
+		policy.Keep = r.FormValue("keep")
+		policy.Folder = r.FormValue("folder")
+		policy.Merge = r.FormValue("merge") != ""
+	}
+	var errMsg string
+	if err := policy.Validate(); err != nil {
+		errMsg = err.Error()
+		policy = dedup.Policy{Keep: dedup.KeepFirst}
+	}
+	head, groups := s.Duplicates(policy)
+
+	if r.Method == http.MethodPost {
+		if !c.allowed(r, name, true) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		choices := make(map[int]int)
+		for g := range groups {
+			if v := r.FormValue("g" + strconv.Itoa(g)); v != "" {
+				e, err := strconv.Atoi(v)
+				if err != nil {
+					http.Error(w, "Bad request", http.StatusBadRequest)
+					return
+				}
+				choices[g] = e
+			}
+		}
+		err := ErrStale
+		if r.FormValue("head") == head {
+			err = Pick(groups, choices)
+		}
+		if err == nil {
+			err = s.Dedup(r.Context(), r.FormValue("head"), groups, r.FormValue("merge") != "")
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Redirect(w, r, proxy.Prefix(r)+"/info?c="+url.QueryEscape(name), http.StatusSeeOther)
+		return
+	}
+
+	csrf := ""
+	if c.CSRF != nil {
+		csrf = c.CSRF(r)
+	}
+	err := duplicatesTpl.Execute(w, map[string]interface{}{
+		"Prefix":     proxy.Prefix(r),
+		"Collection": name,
+		"Keeps":      dedup.Keeps,
+		"Policy":     policy,
+		"Error":      errMsg,
+		"Head":       head,
+		"Groups":     groups,
+		"Similar":    s.Similar(dedup.MinScore, nil),
+		"CanWrite":   c.allowed(r, name, true),
+		"CSRF":       csrf,
+	})
+	if err != nil {
+		logging.From(r.Context()).Error("rendering failed", zap.Error(err))
+	}
+}
+
+// Similar returns clusters of bookmarks of the head revision likely leading
+// to the same content, see dedup.Near.
+func (s *Store) Similar(minScore float64, canonical map[string]string) []dedup.Cluster {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	x := s.get()
+	if x == nil {
+		return nil
+	}
+	return dedup.Near(x, minScore, canonical, s.urls)
+}
@@ -0,0 +1,150 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/dav-m85/xbellum/checker"
+	"github.com/dav-m85/xbellum/xbel"
+)
+
+// What FixLinks does to dead links.
+const (
+	DeadKeep   = ""
+	DeadPrefix = "prefix"
+	DeadMove   = "move"
+)
+
+// Fixes tells how FixLinks changes bookmarks after link checks.
+type Fixes struct {
+	// Dead is DeadPrefix to put Prefix in front of titles of dead links, or
+	// DeadMove to move them into the top level Folder.
+	Dead   string
+	Prefix string
+	Folder string
+	// Redirects replaces links permanently redirected with where they lead.
+	Redirects bool
+	// DeadAfter is how many failed runs make a link dead,
+	// checker.DefaultDeadAfter when zero.
+	DeadAfter int
+}
+
+// Change is a bookmark changed by FixLinks.
+type Change struct {
+	Title string
+	Href  string
+	// What is "prefixed", "moved" or "redirected", To being the new href of
+	// the latter.
+	What string
+	To   string
+}
+
+func (c Change) String() string {
+	if c.To != "" {
+		return fmt.Sprintf("%-10s %s -> %s", c.What, c.Href, c.To)
+	}
+	return fmt.Sprintf("%-10s %s (%s)", c.What, c.Href, c.Title)
+}
+
+// FixLinks applies link health to the head revision as told by f, recording
+// the result as a new revision unless dryRun is set. It returns what changed,
+// nothing being recorded when empty.
+func (s *Store) FixLinks(ctx context.Context, f Fixes, dryRun bool) ([]Change, error) {
+	if f.Dead != DeadKeep && f.Dead != DeadPrefix && f.Dead != DeadMove {
+		return nil, fmt.Errorf("unknown fix %q for dead links", f.Dead)
+	}
+	if f.Dead == DeadPrefix && f.Prefix == "" || f.Dead == DeadMove && f.Folder == "" {
+		return nil, fmt.Errorf("no prefix or folder for dead links")
+	}
+	if f.DeadAfter <= 0 {
+		f.DeadAfter = checker.DefaultDeadAfter
+	}
+	db, err := s.Links()
+	if err != nil {
+		return nil, err
+	}
+
+	// Fixes are made again over revisions recorded meanwhile
+	for attempt := 1; ; attempt++ {
+		s.mu.RLock()
+		head, id := s.get(), s.head()
+		s.mu.RUnlock()
+		if head == nil {
+			return nil, nil
+		}
+		changes, x := fixLinks(head, db, f)
+		if dryRun || len(changes) == 0 {
+			return changes, nil
+		}
+		b := bytes.NewBuffer([]byte{})
+		xbel.Write(b, x)
+		err := s.SetIf(ctx, id, b.Bytes())
+		if err != ErrStale || attempt == maxAttempts {
+			return changes, err
+		}
+	}
+}
+
+// maxAttempts is how many times FixLinks tries to record its changes.
+const maxAttempts = 3
+
+// fixLinks returns head fixed as told by f, and what changed. Folders are
+// kept even when left empty.
+func fixLinks(head *xbel.XBEL, db *checker.HealthDB, f Fixes) ([]Change, *xbel.XBEL) {
+	var changes []Change
+	var moved []xbel.Bookmark
+	fix := func(b *xbel.Bookmark, inFolder bool) bool {
+		h, ok := db.Get(b.Href)
+		if !ok {
+			return true
+		}
+		switch {
+		case f.Redirects && h.Status == checker.Redirected && h.Permanent && h.FinalURL != "" && h.FinalURL != b.Href:
+			changes = append(changes, Change{Title: b.Title, Href: b.Href, What: "redirected", To: h.FinalURL})
+			b.Href = h.FinalURL
+		case h.State(f.DeadAfter) != checker.StateDead:
+		case f.Dead == DeadPrefix && !strings.HasPrefix(b.Title, f.Prefix):
+			changes = append(changes, Change{Title: b.Title, Href: b.Href, What: "prefixed"})
+			b.Title = f.Prefix + b.Title
+		case f.Dead == DeadMove && !inFolder:
+			changes = append(changes, Change{Title: b.Title, Href: b.Href, What: "moved"})
+			moved = append(moved, *b)
+			return false
+		}
+		return true
+	}
+	var fixFolder func(folder xbel.Folder, inFolder bool) xbel.Folder
+	fixFolder = func(folder xbel.Folder, inFolder bool) xbel.Folder {
+		y := folder
+		y.Bookmarks, y.Folders = nil, nil
+		for _, b := range folder.Bookmarks {
+			if fix(&b, inFolder) {
+				y.Bookmarks = append(y.Bookmarks, b)
+			}
+		}
+		for _, child := range folder.Folders {
+			y.Folders = append(y.Folders, fixFolder(child, inFolder))
+		}
+		return y
+	}
+
+	x := &xbel.XBEL{XMLName: head.XMLName, Version: head.Version}
+	deadFolder := -1
+	for _, folder := range head.Folders {
+		isDead := f.Dead == DeadMove && folder.Title == f.Folder
+		if isDead {
+			deadFolder = len(x.Folders)
+		}
+		x.Folders = append(x.Folders, fixFolder(folder, isDead))
+	}
+	if len(moved) > 0 {
+		if deadFolder < 0 {
+			x.Folders = append(x.Folders, xbel.Folder{Title: f.Folder})
+			deadFolder = len(x.Folders) - 1
+		}
+		x.Folders[deadFolder].Bookmarks = append(x.Folders[deadFolder].Bookmarks, moved...)
+	}
+	return changes, x
+}
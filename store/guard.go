@@ -0,0 +1,41 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/dav-m85/xbellum/urlnorm"
+	"github.com/dav-m85/xbellum/xbel"
+)
+
+// ErrRejected is returned by Set when a guard refuses the new revision.
+var ErrRejected = errors.New("revision rejected")
+
+// Guard protects the store against uploads that look like an accident, like
+// a browser sync wiping most bookmarks. Zero values disable checks.
+type Guard struct {
+	// MaxRemoved is the most bookmarks a revision may remove.
+	MaxRemoved int
+	// MaxRemovedRatio is the largest fraction of head bookmarks a revision
+	// may remove, between 0 and 1.
+	MaxRemovedRatio float64
+}
+
+// Check tells if going from head to next is acceptable, links being compared
+// by their key with urls. head may be nil.
+func (g Guard) Check(head, next *xbel.XBEL, urls *urlnorm.Normalizer) error {
+	if head == nil || (g.MaxRemoved == 0 && g.MaxRemovedRatio == 0) {
+		return nil
+	}
+	hb := xbel.Bookmarks(head)
+	_, removed := xbel.Diff(xbel.Bookmarks(next), hb, urls)
+	if g.MaxRemoved > 0 && len(removed) > g.MaxRemoved {
+		return fmt.Errorf("%w: removes %d bookmarks, at most %d allowed", ErrRejected, len(removed), g.MaxRemoved)
+	}
+	if g.MaxRemovedRatio > 0 && len(hb) > 0 {
+		if ratio := float64(len(removed)) / float64(len(hb)); ratio > g.MaxRemovedRatio {
+			return fmt.Errorf("%w: removes %.0f%% of bookmarks, at most %.0f%% allowed", ErrRejected, ratio*100, g.MaxRemovedRatio*100)
+		}
+	}
+	return nil
+}
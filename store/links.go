@@ -0,0 +1,190 @@
+package store
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+
+	"github.com/dav-m85/xbellum/checker"
+	"github.com/dav-m85/xbellum/logging"
+	"github.com/dav-m85/xbellum/proxy"
+	"github.com/dav-m85/xbellum/xbel"
+	"go.uber.org/zap"
+)
+
+// Links returns the health of links checked in the store, kept in
+// checker.HealthFile next to revisions.
+func (s *Store) Links() (*checker.HealthDB, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.links == nil {
+		db, err := checker.OpenHealth(filepath.Join(s.root, checker.HealthFile))
+		if err != nil {
+			return nil, err
+		}
+		s.links = db
+	}
+	return s.links, nil
+}
+
+// Bookmarks returns the bookmarks of the head revision.
+func (s *Store) Bookmarks() []*xbel.Bookmark {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if x := s.get(); x != nil {
+		return xbel.Bookmarks(x)
+	}
+	return nil
+}
+
+var linksTpl = template.Must(template.New("links").Parse(`
+<html>
+<body>
+<p><a href="{{.Prefix}}/info?c={{.Collection}}">Bookmarks</a></p>
+<h2>Link health of {{.Collection}}</h2>
+{{if not .Checked}}<p>Links were never checked, run "xbellum check".</p>{{end}}
+<form method="get">
+	<input type="hidden" name="c" value="{{.Collection}}">
+	Dead links:
+	<select name="dead">
+		<option value="keep"{{if eq .Fixes.Dead ""}} selected{{end}}>keep</option>
+		<option value="prefix"{{if eq .Fixes.Dead "prefix"}} selected{{end}}>prefix with "{{.Fixes.Prefix}}"</option>
+		<option value="move"{{if eq .Fixes.Dead "move"}} selected{{end}}>move to "{{.Fixes.Folder}}"</option>
+	</select>
+	<label><input type="checkbox" name="redirects" value="1"{{if .Fixes.Redirects}} checked{{end}}> replace permanent redirects</label>
+	<input type="submit" name="preview" value="Preview">
+</form>
+{{if .Preview}}
+<h3>{{len .Changes}} change(s)</h3>
+{{range .Changes}}<p>{{.What}} <a href="{{.Href}}">{{.Title}}</a> {{.Href}}{{if .To}} &rarr; {{.To}}{{end}}</p>{{end}}
+{{if and .Changes .CanWrite}}
+<form method="post">
+	<input type="hidden" name="csrf" value="{{.CSRF}}">
+	<input type="hidden" name="c" value="{{.Collection}}">
+	<input type="hidden" name="dead" value="{{.Dead}}">
+	{{if .Fixes.Redirects}}<input type="hidden" name="redirects" value="1">{{end}}
+	<input type="submit" value="Record as a new revision">
+</form>
+{{end}}
+{{end}}
+{{range .Groups}}
+<h3>{{.Title}} ({{len .Links}})</h3>
+<table>
+<tr><th>Bookmark</th><th>Outcome</th><th>Failed runs</th><th>Last checked</th><th>Last working</th></tr>
+{{range .Links}}
+<tr>
+	<td><a href="{{.Href}}">{{.Title}}</a><br><small>{{.Href}}</small>{{if .FinalURL}}<br><small>&rarr; {{.FinalURL}}</small>{{end}}</td>
+	<td>{{.Status}}{{if .Code}} {{.Code}}{{end}}{{if .Error}}<br><small>{{.Error}}</small>{{end}}</td>
+	<td>{{.Failures}}</td>
+	<td>{{.Checked.Format "2006-01-02 15:04"}}</td>
+	<td>{{if .LastOK.IsZero}}never{{else}}{{.LastOK.Format "2006-01-02 15:04"}}{{end}}</td>
+</tr>
+{{end}}
+</table>
+{{end}}
+</body>
+</html>
+`))
+
+type linkRow struct {
+	checker.Health
+	Title string
+}
+
+// serveLinks lists dead, flaky and redirected links of the head revision,
+// previewing and applying fixes.
+func (c *Collections) serveLinks(w http.ResponseWriter, r *http.Request, s *Store, name string) {
+	fixes := c.Fixes
+	if r.FormValue("dead") != "" || r.Method == http.MethodPost {
+		fixes.Dead = r.FormValue("dead")
+		if fixes.Dead == "keep" {
+			fixes.Dead = DeadKeep
+		}
+		fixes.Redirects = r.FormValue("redirects") != ""
+	}
+
+	if r.Method == http.MethodPost {
+		if !c.allowed(r, name, true) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if _, err := s.FixLinks(r.Context(), fixes, false); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Redirect(w, r, proxy.Prefix(r)+"/info?c="+url.QueryEscape(name), http.StatusSeeOther)
+		return
+	}
+
+	db, err := s.Links()
+	if err != nil {
+		logging.From(r.Context()).Error("reading link health failed", zap.Error(err))
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	deadAfter := fixes.DeadAfter
+	if deadAfter <= 0 {
+		deadAfter = checker.DefaultDeadAfter
+	}
+	var changes []Change
+	preview := r.FormValue("preview") != ""
+	if preview {
+		if changes, err = s.FixLinks(r.Context(), fixes, true); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	dead := fixes.Dead
+	if dead == DeadKeep {
+		dead = "keep"
+	}
+	csrf := ""
+	if c.CSRF != nil {
+		csrf = c.CSRF(r)
+	}
+
+	type group struct {
+		Title string
+		Links []linkRow
+	}
+	groups := []*group{{Title: "Dead"}, {Title: "Flaky"}, {Title: "Redirected"}}
+	byState := map[string]*group{
+		checker.StateDead:       groups[0],
+		checker.StateFlaky:      groups[1],
+		checker.StateRedirected: groups[2],
+	}
+	checked := false
+	seen := make(map[string]bool)
+	for _, b := range s.Bookmarks() {
+		h, ok := db.Get(b.Href)
+		if !ok || seen[b.Href] {
+			continue
+		}
+		seen[b.Href] = true
+		checked = true
+		if g, ok := byState[h.State(deadAfter)]; ok {
+			g.Links = append(g.Links, linkRow{Health: h, Title: b.Title})
+		}
+	}
+	for _, g := range groups {
+		sort.Slice(g.Links, func(i, j int) bool { return g.Links[i].Failures > g.Links[j].Failures })
+	}
+
+	err = linksTpl.Execute(w, map[string]interface{}{
+		"Prefix":     proxy.Prefix(r),
+		"Collection": name,
+		"Checked":    checked,
+		"Groups":     groups,
+		"Fixes":      fixes,
+		"Dead":       dead,
+		"Preview":    preview,
+		"Changes":    changes,
+		"CanWrite":   c.allowed(r, name, true),
+		"CSRF":       csrf,
+	})
+	if err != nil {
+		logging.From(r.Context()).Error("rendering failed", zap.Error(err))
+	}
+}
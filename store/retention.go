@@ -0,0 +1,61 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dav-m85/xbellum/search"
+)
+
+// Retention tells which revisions Prune may remove. Zero values keep
+// everything.
+type Retention struct {
+	// Keep is how many of the latest revisions are always kept.
+	Keep int
+	// MaxAge is how long revisions beyond Keep are kept.
+	MaxAge time.Duration
+}
+
+// Prune removes revisions falling out of the retention policy, and returns
+// their ids. Head and tagged revisions are never removed.
+func (s *Store) Prune(r Retention) ([]string, error) {
+	if r.Keep == 0 && r.MaxAge == 0 {
+		return nil, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+	tagged := make(map[string]bool)
+	for _, id := range s.tags {
+		tagged[id] = true
+	}
+
+	var kept []version
+	var removed []string
+	var err error
+	for i, v := range s.versions {
+		recent := len(s.versions)-i <= r.Keep || (r.Keep == 0 && i == len(s.versions)-1)
+		young := r.MaxAge > 0 && time.Since(v.created) < r.MaxAge
+		if recent || young || tagged[v.id] || err != nil {
+			kept = append(kept, v)
+			continue
+		}
+		if err = os.Remove(filepath.Join(s.root, v.id)); err != nil && !os.IsNotExist(err) {
+			kept = append(kept, v)
+			continue
+		}
+		err = nil
+		removed = append(removed, v.id)
+	}
+	s.versions = kept
+	if len(removed) > 0 {
+		s.index = search.New(s.urls)
+		for _, v := range s.versions {
+			s.index.Add(v.id, v.xb)
+		}
+	}
+	return removed, err
+}
@@ -3,13 +3,51 @@ package store
 import (
 	"html/template"
 	"net/http"
+	"net/url"
+
+	"github.com/dav-m85/xbellum/proxy"
+	"github.com/dav-m85/xbellum/search"
 )
 
 var tplStr string = `
 <html>
 <body>
-{{range .}}
+<p style="float: right"><a href="{{.Prefix}}/info/links?c={{.Collection}}">Link health</a> <a href="{{.Prefix}}/info/duplicates?c={{.Collection}}">Duplicates</a> <a href="{{.Prefix}}/info/tokens">API tokens</a></p>
+{{if .CSRF}}
+<form method="post" action="{{.Prefix}}/logout" style="float: right">
+	<input type="hidden" name="csrf" value="{{.CSRF}}">
+	<input type="submit" value="Log out">
+</form>
+{{end}}
+{{if gt (len .Collections) 1}}
+<p>{{range .Collections}}
+	{{if eq . $.Collection}}<b>{{.}}</b>{{else}}<a href="?c={{.}}">{{.}}</a>{{end}}
+{{end}}</p>
+{{end}}
+<form method="get">
+	<input type="hidden" name="c" value="{{.Collection}}">
+	<input type="search" name="q" value="{{.Query}}" placeholder="Search bookmarks">
+	<label><input type="checkbox" name="history" value="1" {{if .History}}checked{{end}}> in history</label>
+	<input type="submit" value="Search">
+</form>
+{{if .Query}}
+<h2>{{len .Hits}} result(s) for "{{.Query}}"</h2>
+		{{range .Hits}}
+		<p{{if not .InHead}} style="color: grey"{{end}}><a href="{{.Href}}">{{.Title}}</a> {{.Href}}
+		{{if .Desc}}<br><i>{{.Desc}}</i>{{end}}
+		<br><small>in {{range .Revisions}}{{.}} {{end}}</small></p>
+		{{end}}
+{{else}}
+{{range .Diffs}}
 <h2>{{.Version}} (from {{.ParentVersion}})</h2>
+{{if $.CanWrite}}
+<form method="post" action="{{.Prefix}}/info/restore">
+	<input type="hidden" name="csrf" value="{{$.CSRF}}">
+	<input type="hidden" name="c" value="{{$.Collection}}">
+	<input type="hidden" name="version" value="{{.Version}}">
+	<input type="submit" value="Restore">
+</form>
+{{end}}
 <p><b>Adds {{len .Adds}}</b></p>
 <p><b>Removes {{len .Removes}}</b></p>
 		{{range .Adds}}
@@ -19,16 +57,93 @@ var tplStr string = `
 		<p style="color: red">- {{.Href}}</p>
 	{{end}}
 {{end}}
+{{end}}
 </body>
 </html>
 `
 
-func (s *Store) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+func (c *Collections) allowed(r *http.Request, collection string, write bool) bool {
+	return c.Allowed == nil || c.Allowed(r, collection, write)
+}
+
+// ServeHTTP serves the web UI under /info, working on the collection picked
+// with the c parameter, DefaultCollection by default.
+func (c *Collections) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := r.FormValue("c")
+	if name == "" {
+		name = DefaultCollection
+	}
+	s, err := c.Open(name, false)
+	if err != nil || !c.allowed(r, name, false) {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/info":
+		c.serveIndex(w, r, s, name)
+	case "/info/links":
+		c.serveLinks(w, r, s, name)
+	case "/info/duplicates":
+		c.serveDuplicates(w, r, s, name)
+	case "/info/restore":
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !c.allowed(r, name, true) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if err := s.Restore(r.Context(), r.FormValue("version")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Redirect(w, r, proxy.Prefix(r)+"/info?c="+url.QueryEscape(name), http.StatusSeeOther)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (c *Collections) serveIndex(w http.ResponseWriter, r *http.Request, s *Store, name string) {
 	tpl, _ := template.New("main").Parse(tplStr)
 
 	diffs, _ := s.DiffAll()
 
-	err := tpl.Execute(w, diffs)
+	var names []string
+	for _, n := range c.Names() {
+		if c.allowed(r, n, false) {
+			names = append(names, n)
+		}
+	}
+
+	data := struct {
+		Collections []string
+		Collection  string
+		CanWrite    bool
+		Query       string
+		History     bool
+		Hits        []search.Hit
+		Diffs       []Diff
+		CSRF        string
+		Prefix      string
+	}{
+		Collections: names,
+		Collection:  name,
+		CanWrite:    c.allowed(r, name, true),
+		Query:       r.FormValue("q"),
+		History:     r.FormValue("history") != "",
+		Diffs:       diffs,
+		Prefix:      proxy.Prefix(r),
+	}
+	if c.CSRF != nil {
+		data.CSRF = c.CSRF(r)
+	}
+	if data.Query != "" {
+		data.Hits = s.Search(data.Query, data.History)
+	}
+
+	err := tpl.Execute(w, data)
 	if err != nil {
 		panic(err)
 	}
@@ -2,15 +2,26 @@ package store
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/dav-m85/xbellum/checker"
+	"github.com/dav-m85/xbellum/logging"
+	"github.com/dav-m85/xbellum/metrics"
+	"github.com/dav-m85/xbellum/search"
+	"github.com/dav-m85/xbellum/urlnorm"
 	"github.com/dav-m85/xbellum/xbel"
+	"go.uber.org/zap"
 )
 
 type version struct {
@@ -19,14 +30,37 @@ type version struct {
 	xb      *xbel.XBEL
 }
 
+// ErrClosed is returned when writing to a closed store.
+var ErrClosed = errors.New("store closed")
+
+// ErrStale is returned when the head revision changed since it was read.
+var ErrStale = errors.New("bookmarks changed meanwhile, try again")
+
+var rejected = metrics.Default.NewCounter("xbellum_uploads_rejected_total",
+	"Revisions refused, because invalid or stopped by guards.", "reason")
+
 type Store struct {
+	// mu guards everything below, writes holding it until files are on disk
+	mu        sync.RWMutex
+	closed    bool
 	increment int
-	// mu        sync.Mutex
-	versions []version
-	root     string
+	versions  []version
+	root      string
+	index     *search.Index
+	// tags maps a tag name to a version id
+	tags map[string]string
+	// links is opened by Links
+	links *checker.HealthDB
+	// urls compares links, see urlnorm.
+	urls *urlnorm.Normalizer
+
+	// Guard checks revisions before they are recorded.
+	Guard Guard
 }
 
-func NewStore(root string) *Store {
+// NewStore loads the revisions in root, links being compared by their key
+// with urls.
+func NewStore(root string, urls *urlnorm.Normalizer) *Store {
 	reg := regexp.MustCompile(`^bkm_(\d{6}).xbel$`)
 	fs, err := ioutil.ReadDir(root)
 	if err != nil {
@@ -34,8 +68,10 @@ func NewStore(root string) *Store {
 	}
 	st := Store{
 		increment: -1,
-		versions:  make([]version, len(fs)),
 		root:      root,
+		index:     search.New(urls),
+		tags:      make(map[string]string),
+		urls:      urls,
 	}
 	for _, f := range fs {
 		if m := reg.FindStringSubmatch(f.Name()); m != nil {
@@ -51,17 +87,27 @@ func NewStore(root string) *Store {
 			if err != nil {
 				panic(err)
 			}
-			st.versions[inc] = version{
+			st.versions = append(st.versions, version{
 				id:      f.Name(),
 				xb:      xb,
 				created: f.ModTime(),
-			}
+			})
 			if inc > st.increment {
 				st.increment = inc
 			}
 		}
 	}
-	log.Printf("Store increment:%d", st.increment)
+	// Names are zero padded, but let's not rely on ReadDir ordering
+	sort.Slice(st.versions, func(i, j int) bool {
+		return st.versions[i].id < st.versions[j].id
+	})
+	for _, v := range st.versions {
+		st.index.Add(v.id, v.xb)
+	}
+	if err := st.loadTags(); err != nil {
+		panic(err)
+	}
+	zap.L().Debug("store loaded", zap.String("root", root), zap.Int("increment", st.increment))
 	return &st
 }
 
@@ -73,7 +119,9 @@ func (s *Store) get() *xbel.XBEL {
 }
 
 func (s *Store) Get() ([]byte, error) {
+	s.mu.RLock()
 	x := s.get()
+	s.mu.RUnlock()
 	if x == nil {
 		return nil, fmt.Errorf("no version available")
 	}
@@ -82,21 +130,132 @@ func (s *Store) Get() ([]byte, error) {
 	return b.Bytes(), nil
 }
 
-func (s *Store) Set(d []byte) error {
+// Set records d as a new revision, ctx carrying the request ID to log.
+func (s *Store) Set(ctx context.Context, d []byte) error {
+	return s.set(ctx, d, nil)
+}
+
+// SetIf is Set for changes made to revision head, failing with ErrStale when
+// another revision was recorded since.
+func (s *Store) SetIf(ctx context.Context, head string, d []byte) error {
+	return s.set(ctx, d, &head)
+}
+
+func (s *Store) set(ctx context.Context, d []byte, head *string) error {
+	l := logging.From(ctx).With(zap.String("root", s.root))
+	xb, err := xbel.Parse(d)
+	if err != nil {
+		rejected.Inc("invalid")
+		l.Warn("invalid revision rejected", zap.Error(err))
+		return fmt.Errorf("%w: %s", ErrRejected, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if head != nil && s.head() != *head {
+		return ErrStale
+	}
+	if err := s.Guard.Check(s.get(), xb, s.urls); err != nil {
+		rejected.Inc("guard")
+		l.Warn("revision rejected by guards", zap.Error(err))
+		return err
+	}
+
+	id := fmt.Sprintf("bkm_%06d.xbel", s.increment+1)
+	// TODO If different from actual, record new instance
+	if err := writeFile(filepath.Join(s.root, id), d); err != nil {
+		return err
+	}
 	s.increment++
-	fn := filepath.Join(s.root, fmt.Sprintf("bkm_%06d.xbel", s.increment))
-	log.Printf("Store increment:%d", s.increment)
+	l.Info("revision recorded", zap.String("id", id), zap.Int("size", len(d)))
 
 	s.versions = append(s.versions, version{
-		id:      fn,
-		xb:      xbel.MustParse(d),
+		id:      id,
+		xb:      xb,
 		created: time.Now(),
 	})
-	// TODO If different from actual, record new instance
-	return ioutil.WriteFile(fn, d, 0666)
+	s.index.Add(id, xb)
+	return nil
+}
+
+// writeFile writes data to fn through a temporary file, so that fn is either
+// complete or missing, even when interrupted.
+func writeFile(fn string, data []byte) error {
+	tmp := filepath.Join(filepath.Dir(fn), "."+filepath.Base(fn)+".tmp")
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, fn)
+}
+
+// Close waits for a write in progress, and makes further ones fail with
+// ErrClosed.
+func (s *Store) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+}
+
+// Stats returns the number of revisions, and of bookmarks in head.
+func (s *Store) Stats() (revisions, bookmarks int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if x := s.get(); x != nil {
+		bookmarks = len(xbel.Bookmarks(x))
+	}
+	return len(s.versions), bookmarks
+}
+
+// Head returns the id of the latest revision, empty when there is none.
+func (s *Store) Head() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.head()
+}
+
+func (s *Store) head() string {
+	if len(s.versions) == 0 {
+		return ""
+	}
+	return s.versions[len(s.versions)-1].id
+}
+
+// Restore records revision id again as a new revision.
+func (s *Store) Restore(ctx context.Context, id string) error {
+	d, err := s.Revision(id)
+	if err != nil {
+		return err
+	}
+	return s.Set(ctx, d)
+}
+
+// Search looks for query in head revision bookmarks, or in all revisions
+// when history is set.
+func (s *Store) Search(query string, history bool) []search.Hit {
+	s.mu.RLock()
+	index := s.index
+	s.mu.RUnlock()
+	return index.Search(query, history)
 }
 
 func (s *Store) DiffAll() ([]Diff, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	var diffs []Diff
 	if len(s.versions) == 0 {
 		return diffs, nil
@@ -106,7 +265,7 @@ func (s *Store) DiffAll() ([]Diff, error) {
 		// Compare parent and v
 		vb := xbel.Bookmarks(v.xb)
 		pb := xbel.Bookmarks(parent.xb)
-		added, removed := xbel.Diff(vb, pb)
+		added, removed := xbel.Diff(vb, pb, s.urls)
 		if len(added) > 0 || len(removed) > 0 {
 			diffs = append(diffs, Diff{
 				Version:       v.id,
@@ -128,3 +287,125 @@ type Diff struct {
 	Adds          []*xbel.Bookmark
 	Removes       []*xbel.Bookmark
 }
+
+// Revisions lists revision files, oldest first.
+func (s *Store) Revisions() ([]os.FileInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	infos := make([]os.FileInfo, 0, len(s.versions))
+	for _, v := range s.versions {
+		fi, err := os.Stat(filepath.Join(s.root, v.id))
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, fi)
+	}
+	return infos, nil
+}
+
+// Revision returns the content of revision id, as it was received.
+func (s *Store) Revision(id string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.has(id) {
+		return nil, os.ErrNotExist
+	}
+	return ioutil.ReadFile(filepath.Join(s.root, id))
+}
+
+func (s *Store) has(id string) bool {
+	for _, v := range s.versions {
+		if v.id == id {
+			return true
+		}
+	}
+	return false
+}
+
+const tagsFile = "tags"
+
+var tagReg = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// Tags returns a copy of tag names and the revision id they point to.
+func (s *Store) Tags() map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	tags := make(map[string]string, len(s.tags))
+	for k, v := range s.tags {
+		tags[k] = v
+	}
+	return tags
+}
+
+// Tag names revision id, head when id is empty. An existing tag with the
+// same name is moved.
+func (s *Store) Tag(name, id string) error {
+	if !tagReg.MatchString(name) {
+		return fmt.Errorf("invalid tag name %q", name)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if id == "" {
+		if len(s.versions) == 0 {
+			return fmt.Errorf("no version available")
+		}
+		id = s.versions[len(s.versions)-1].id
+	}
+	if !s.has(id) {
+		return fmt.Errorf("unknown revision %q", id)
+	}
+	s.tags[name] = id
+	return s.saveTags()
+}
+
+// Untag removes tag name.
+func (s *Store) Untag(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if _, ok := s.tags[name]; !ok {
+		return fmt.Errorf("unknown tag %q", name)
+	}
+	delete(s.tags, name)
+	return s.saveTags()
+}
+
+// loadTags reads the tags file, one "name revision" per line.
+func (s *Store) loadTags() error {
+	buf, err := ioutil.ReadFile(filepath.Join(s.root, tagsFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for i, line := range strings.Split(string(buf), "\n") {
+		f := strings.Fields(line)
+		if len(f) == 0 {
+			continue
+		}
+		if len(f) != 2 {
+			return fmt.Errorf("%s:%d: expected \"name revision\"", tagsFile, i+1)
+		}
+		s.tags[f[0]] = f[1]
+	}
+	return nil
+}
+
+func (s *Store) saveTags() error {
+	names := make([]string, 0, len(s.tags))
+	for k := range s.tags {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	b := bytes.NewBuffer([]byte{})
+	for _, n := range names {
+		fmt.Fprintf(b, "%s %s\n", n, s.tags[n])
+	}
+	return writeFile(filepath.Join(s.root, tagsFile), b.Bytes())
+}
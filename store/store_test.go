@@ -0,0 +1,288 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dav-m85/xbellum/checker"
+	"github.com/dav-m85/xbellum/dedup"
+	"github.com/dav-m85/xbellum/urlnorm"
+	"github.com/dav-m85/xbellum/xbel"
+	"github.com/matryer/is"
+)
+
+// revision builds an XBEL file holding n bookmarks.
+func revision(n int) []byte {
+	var bs []xbel.Bookmark
+	for i := 0; i < n; i++ {
+		bs = append(bs, xbel.Bookmark{Title: "b", Href: fmt.Sprintf("https://example.com/%d", i)})
+	}
+	b := bytes.NewBuffer([]byte{})
+	xbel.Write(b, &xbel.XBEL{
+		Version: xbel.SUPPORTED_VERSION,
+		Folders: []xbel.Folder{{Title: "root", Bookmarks: bs}},
+	})
+	return b.Bytes()
+}
+
+func TestGuard(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	s := NewStore(t.TempDir(), urlnorm.New())
+	s.Guard = Guard{MaxRemoved: 3, MaxRemovedRatio: 0.5}
+
+	is.NoErr(s.Set(ctx, revision(10)))
+	is.NoErr(s.Set(ctx, revision(7)))
+	err := s.Set(ctx, revision(3))
+	is.True(errors.Is(err, ErrRejected))
+	is.Equal(s.Head(), "bkm_000001.xbel")
+
+	// Broken uploads never make it
+	err = s.Set(ctx, []byte("<xbel"))
+	is.True(errors.Is(err, ErrRejected))
+
+	s.Guard = Guard{MaxRemovedRatio: 0.5}
+	is.NoErr(s.Set(ctx, revision(4)))
+	is.True(errors.Is(s.Set(ctx, revision(1)), ErrRejected))
+}
+
+func TestPrune(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	s := NewStore(dir, urlnorm.New())
+	for i := 1; i <= 6; i++ {
+		is.NoErr(s.Set(ctx, revision(i)))
+	}
+	is.NoErr(s.Tag("first", "bkm_000000.xbel"))
+
+	removed, err := s.Prune(Retention{})
+	is.NoErr(err)
+	is.Equal(len(removed), 0)
+
+	removed, err = s.Prune(Retention{Keep: 2})
+	is.NoErr(err)
+	is.Equal(removed, []string{"bkm_000001.xbel", "bkm_000002.xbel", "bkm_000003.xbel"})
+
+	// What is left survives a reload
+	s = NewStore(dir, urlnorm.New())
+	infos, err := s.Revisions()
+	is.NoErr(err)
+	is.Equal(len(infos), 3)
+	is.Equal(s.Head(), "bkm_000005.xbel")
+	is.Equal(len(s.Search("example", true)), 6)
+}
+
+func TestClose(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	s := NewStore(dir, urlnorm.New())
+	is.NoErr(s.Set(ctx, revision(1)))
+	s.Close()
+	is.Equal(s.Set(ctx, revision(2)), ErrClosed)
+	is.Equal(s.Tag("v1", ""), ErrClosed)
+
+	// Only complete revisions are on disk
+	files, err := ioutil.ReadDir(dir)
+	is.NoErr(err)
+	is.Equal(len(files), 1)
+	is.Equal(files[0].Name(), "bkm_000000.xbel")
+	is.Equal(NewStore(dir, urlnorm.New()).Head(), "bkm_000000.xbel")
+}
+
+func TestLinks(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	lib := NewCollections(t.TempDir(), Guard{}, urlnorm.New())
+	lib.Fixes = Fixes{Prefix: "[dead] ", Folder: "Dead links"}
+	s, err := lib.Open(DefaultCollection, false)
+	is.NoErr(err)
+	is.NoErr(s.Set(ctx, revision(3)))
+
+	db, err := s.Links()
+	is.NoErr(err)
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		is.NoErr(db.Record([]checker.Result{
+			{URL: "https://example.com/0", Status: checker.OK, Code: 200},
+			{URL: "https://example.com/1", Status: checker.ClientError, Code: 404},
+			// No longer bookmarked
+			{URL: "https://example.com/9", Status: checker.ClientError, Code: 404},
+		}, now))
+	}
+
+	w := httptest.NewRecorder()
+	lib.ServeHTTP(w, httptest.NewRequest("GET", "/info/links", nil))
+	is.Equal(w.Code, http.StatusOK)
+	body := w.Body.String()
+	is.True(strings.Contains(body, "Dead (1)"))
+	is.True(strings.Contains(body, "https://example.com/1"))
+	is.True(!strings.Contains(body, "https://example.com/9"))
+	is.True(!strings.Contains(body, "https://example.com/0<"))
+
+	// Fixes are previewed, then recorded
+	w = httptest.NewRecorder()
+	lib.ServeHTTP(w, httptest.NewRequest("GET", "/info/links?dead=prefix&preview=1", nil))
+	is.True(strings.Contains(w.Body.String(), "1 change(s)"))
+	is.Equal(s.Head(), "bkm_000000.xbel")
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/info/links", strings.NewReader("dead=prefix"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	lib.ServeHTTP(w, r)
+	is.Equal(w.Code, http.StatusSeeOther)
+	is.Equal(s.Head(), "bkm_000001.xbel")
+}
+
+func TestFixLinks(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	s := NewStore(t.TempDir(), urlnorm.New())
+	is.NoErr(s.Set(ctx, revision(4)))
+	db, err := s.Links()
+	is.NoErr(err)
+	for i := 0; i < 3; i++ {
+		is.NoErr(db.Record([]checker.Result{
+			// Redirecting to itself, as recorded by older checks
+			{URL: "https://example.com/0", Status: checker.Redirected, FinalURL: "https://example.com/0", Permanent: true},
+			{URL: "https://example.com/1", Status: checker.ClientError, Code: 404},
+			{URL: "https://example.com/2", Status: checker.Redirected, FinalURL: "https://example.org/2", Permanent: true},
+			{URL: "https://example.com/3", Status: checker.Redirected, FinalURL: "https://example.org/login"},
+		}, time.Now()))
+	}
+
+	_, err = s.FixLinks(ctx, Fixes{Dead: "delete"}, false)
+	is.True(err != nil)
+
+	// Dry runs tell what would change
+	f := Fixes{Dead: DeadPrefix, Prefix: "[dead] ", Redirects: true}
+	changes, err := s.FixLinks(ctx, f, true)
+	is.NoErr(err)
+	is.Equal(len(changes), 2)
+	is.Equal(changes[0].What, "prefixed")
+	is.Equal(changes[1].To, "https://example.org/2")
+	revs, _ := s.Revisions()
+	is.Equal(len(revs), 1)
+
+	changes, err = s.FixLinks(ctx, f, false)
+	is.NoErr(err)
+	is.Equal(len(changes), 2)
+	titles := make(map[string]string)
+	for _, b := range s.Bookmarks() {
+		titles[b.Href] = b.Title
+	}
+	is.Equal(titles, map[string]string{
+		"https://example.com/0": "b",
+		"https://example.com/1": "[dead] b",
+		"https://example.org/2": "b",
+		"https://example.com/3": "b",
+	})
+	// Applying again changes nothing
+	changes, err = s.FixLinks(ctx, f, false)
+	is.NoErr(err)
+	is.Equal(len(changes), 0)
+
+	// Moving into a folder, kept there on the next run
+	f = Fixes{Dead: DeadMove, Folder: "Dead links"}
+	is.NoErr(s.Set(ctx, revision(4)))
+	changes, err = s.FixLinks(ctx, f, false)
+	is.NoErr(err)
+	is.Equal(len(changes), 1)
+	buf, _ := s.Get()
+	x, err := xbel.Parse(buf)
+	is.NoErr(err)
+	is.Equal(len(x.Folders), 2)
+	is.Equal(x.Folders[1].Title, "Dead links")
+	is.Equal(x.Folders[1].Bookmarks[0].Href, "https://example.com/1")
+	changes, err = s.FixLinks(ctx, f, false)
+	is.NoErr(err)
+	is.Equal(len(changes), 0)
+
+	// Empty folders, or emptied ones, are kept
+	b := bytes.NewBuffer([]byte{})
+	xbel.Write(b, &xbel.XBEL{Version: xbel.SUPPORTED_VERSION, Folders: []xbel.Folder{
+		{Title: "root", Folders: []xbel.Folder{{Title: "empty"}, {Title: "dead", Bookmarks: []xbel.Bookmark{{Title: "b", Href: "https://example.com/1"}}}}},
+		{Title: "later"},
+	}})
+	is.NoErr(s.Set(ctx, b.Bytes()))
+	changes, err = s.FixLinks(ctx, f, false)
+	is.NoErr(err)
+	is.Equal(len(changes), 1)
+	buf, _ = s.Get()
+	x, err = xbel.Parse(buf)
+	is.NoErr(err)
+	is.Equal(len(x.Folders), 3)
+	is.Equal(x.Folders[1].Title, "later")
+	is.Equal(len(x.Folders[0].Folders), 2)
+	is.Equal(len(x.Folders[0].Folders[1].Bookmarks), 0)
+}
+
+func TestSetIf(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	s := NewStore(t.TempDir(), urlnorm.New())
+	is.NoErr(s.SetIf(ctx, "", revision(1)))
+	head := s.Head()
+	is.NoErr(s.Set(ctx, revision(2)))
+	// Made on a revision that is no longer head
+	is.Equal(s.SetIf(ctx, head, revision(3)), ErrStale)
+	is.NoErr(s.SetIf(ctx, s.Head(), revision(3)))
+	revs, _ := s.Revisions()
+	is.Equal(len(revs), 3)
+}
+
+func TestDedup(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	lib := NewCollections(t.TempDir(), Guard{}, urlnorm.New())
+	lib.Dedup = dedup.Policy{Keep: dedup.KeepNewest}
+	s, err := lib.Open(DefaultCollection, false)
+	is.NoErr(err)
+	// Twice the same links, with and without trailing slashes
+	d := revision(3)
+	d = bytes.Replace(d, []byte("</bookmark>"), []byte("</bookmark><bookmark href=\"https://example.com/0/\"><title>b</title></bookmark>"), 1)
+	is.NoErr(s.Set(ctx, d))
+
+	head, groups := s.Duplicates(dedup.Policy{})
+	is.Equal(head, s.Head())
+	is.Equal(len(groups), 1)
+	is.Equal(groups[0].Keep, 0)
+	is.True(Pick(groups, map[int]int{0: 5}) != nil) // no such bookmark
+
+	// The page posts its choices
+	rec := httptest.NewRecorder()
+	lib.ServeHTTP(rec, httptest.NewRequest("GET", "/info/duplicates", nil))
+	is.Equal(rec.Code, http.StatusOK)
+	is.True(strings.Contains(rec.Body.String(), `name="g0" value="1"`))
+	post := func(head string) int {
+		form := strings.NewReader("head=" + head + "&g0=1")
+		req := httptest.NewRequest("POST", "/info/duplicates", form)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		lib.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	is.Equal(post("bkm_000042.xbel"), http.StatusBadRequest) // stale
+	is.Equal(post(head), http.StatusSeeOther)
+
+	is.Equal(s.Head(), "bkm_000001.xbel")
+	bs := s.Bookmarks()
+	is.Equal(len(bs), 3)
+	is.Equal(bs[0].Href, "https://example.com/0/") // picked
+	is.True(errors.Is(s.Dedup(ctx, head, groups, false), ErrStale))
+}
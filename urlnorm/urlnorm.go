@@ -0,0 +1,130 @@
+// Package urlnorm tells when two links lead to the same page, despite
+// differing in ways like http and https, www., trailing slashes, default
+// ports, fragments or tracking parameters.
+package urlnorm
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Tracking are query parameters added by analytics and newsletters. A name
+// ending in * covers every parameter it prefixes.
+var Tracking = []string{
+	"utm_*", "fbclid", "gclid", "dclid", "msclkid", "yclid", "mc_cid", "mc_eid",
+	"igshid", "_ga", "_gl", "ref_src", "spm", "oly_enc_id", "oly_anon_id", "vero_id",
+}
+
+// Normalizer turns links into keys, links with the same key being
+// duplicates. Host names are always compared regardless of case, and links
+// other than http and https are left as they are.
+type Normalizer struct {
+	// Scheme considers http and https the same.
+	Scheme bool
+	// WWW ignores a leading www. in host names.
+	WWW bool
+	// TrailingSlash ignores a / ending the path.
+	TrailingSlash bool
+	// DefaultPort ignores :80 for http and :443 for https.
+	DefaultPort bool
+	// Fragment ignores what follows #, unless it looks like a route of a
+	// single page application, starting with / or !.
+	Fragment bool
+	// SortQuery ignores the order of query parameters.
+	SortQuery bool
+	// Tracking lists query parameters to ignore, see Tracking.
+	Tracking []string
+}
+
+// New returns a normalizer applying every rule.
+func New() *Normalizer {
+	return &Normalizer{
+		Scheme:        true,
+		WWW:           true,
+		TrailingSlash: true,
+		DefaultPort:   true,
+		Fragment:      true,
+		SortQuery:     true,
+		Tracking:      Tracking,
+	}
+}
+
+// Key returns what href is compared by. It is meant for comparisons, not
+// to be fetched. A nil normalizer returns href as is.
+func (n *Normalizer) Key(href string) string {
+	if n == nil {
+		return href
+	}
+	href = strings.TrimSpace(href)
+	u, err := url.Parse(href)
+	if err != nil || u.Host == "" || u.Opaque != "" {
+		return href
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return href
+	}
+
+	host, port := strings.ToLower(u.Hostname()), u.Port()
+	if n.DefaultPort && (u.Scheme == "http" && port == "80" || u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if n.WWW {
+		host = strings.TrimPrefix(host, "www.")
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	u.Host = host
+	if n.Scheme {
+		u.Scheme = "https"
+	}
+	if n.TrailingSlash {
+		u.RawPath = strings.TrimSuffix(u.EscapedPath(), "/")
+		u.Path = strings.TrimSuffix(u.Path, "/")
+	} else if u.Path == "" {
+		u.Path = "/"
+	}
+	if n.Fragment && !strings.HasPrefix(u.Fragment, "/") && !strings.HasPrefix(u.Fragment, "!") {
+		u.Fragment, u.RawFragment = "", ""
+	}
+
+	var params []string
+	for _, p := range strings.Split(u.RawQuery, "&") {
+		if p != "" && !n.tracking(p) {
+			params = append(params, p)
+		}
+	}
+	if n.SortQuery {
+		sort.Strings(params)
+	}
+	u.RawQuery = strings.Join(params, "&")
+	u.ForceQuery = false
+	return u.String()
+}
+
+// tracking tells if the query parameter p is a tracking one.
+func (n *Normalizer) tracking(p string) bool {
+	name := p
+	if i := strings.IndexByte(p, '='); i >= 0 {
+		name = p[:i]
+	}
+	if un, err := url.QueryUnescape(name); err == nil {
+		name = un
+	}
+	name = strings.ToLower(name)
+	for _, t := range n.Tracking {
+		if strings.HasSuffix(t, "*") {
+			if strings.HasPrefix(name, strings.TrimSuffix(t, "*")) {
+				return true
+			}
+		} else if name == t {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package urlnorm
+
+import (
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestKey(t *testing.T) {
+	is := is.New(t)
+	n := New()
+
+	same := [][]string{
+		{"https://example.com/a", "http://example.com/a", "https://www.example.com/a/", "HTTPS://Example.COM:443/a"},
+		{"http://example.com", "https://example.com/", "http://example.com:80/"},
+		{"https://example.com/a?x=1&y=2", "https://example.com/a?y=2&x=1&utm_source=news&utm_medium=mail", "https://example.com/a?x=1&fbclid=abc&y=2#top"},
+		{"https://[::1]:8080/a", "http://[::1]:8080/a/"},
+	}
+	for _, hrefs := range same {
+		for _, h := range hrefs[1:] {
+			is.Equal(n.Key(h), n.Key(hrefs[0])) // same page
+		}
+	}
+
+	different := [][2]string{
+		{"https://example.com/a", "https://example.com/b"},
+		{"https://example.com/a?x=1", "https://example.com/a?x=2"},
+		{"http://example.com:8080/", "http://example.com/"},
+		{"https://app.example.com/#/inbox", "https://app.example.com/#/sent"},
+		{"https://example.com/A", "https://example.com/a"},
+	}
+	for _, d := range different {
+		is.True(n.Key(d[0]) != n.Key(d[1])) // different pages
+	}
+
+	// Others are left alone
+	for _, h := range []string{"javascript:void(0)", "place:sort=8", "ftp://Example.com/a/", "foo", ""} {
+		is.Equal(n.Key(h), h)
+	}
+
+	// Rules are toggled one by one
+	n = &Normalizer{WWW: true, Tracking: []string{"ref"}}
+	is.Equal(n.Key("https://www.Example.com?ref=x&b=1&a=2"), "https://example.com/?b=1&a=2")
+	is.True(n.Key("http://example.com/") != n.Key("https://example.com/"))
+	is.True(n.Key("https://example.com/a/") != n.Key("https://example.com/a"))
+	is.True(n.Key("https://example.com/#a") != n.Key("https://example.com/"))
+
+	var none *Normalizer
+	is.Equal(none.Key("http://www.example.com/"), "http://www.example.com/")
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/dav-m85/xbellum/auth"
+	"github.com/dav-m85/xbellum/config"
+	"github.com/dav-m85/xbellum/dedup"
+	"github.com/dav-m85/xbellum/logging"
+	"github.com/dav-m85/xbellum/store"
+	"github.com/dav-m85/xbellum/urlnorm"
+	"github.com/dav-m85/xbellum/vfs"
+	dav "github.com/dav-m85/xbellum/webdav"
+	"go.uber.org/zap"
+	"golang.org/x/net/webdav"
+)
+
+const usersDir = "users"
+
+// loadUsers reads a users file, one "username:password[:role[:rules]]" per
+// line. Password is plain text (without colons) or a hash-password result, role
+// is read, write (the default) or admin, and rules a comma separated list of
+// path=access overriding the role on some paths, later ones winning. Empty
+// lines and lines starting with # are ignored.
+func loadUsers(fn string) (map[string]*dav.User, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	users := make(map[string]*dav.User)
+	sc := bufio.NewScanner(f)
+	for i := 1; sc.Scan(); i++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 4)
+		if len(parts) < 2 || !dav.ValidUsername(parts[0]) {
+			return nil, fmt.Errorf("%s:%d: expected \"username:password[:role[:rules]]\"", fn, i)
+		}
+		if _, dup := users[parts[0]]; dup {
+			return nil, fmt.Errorf("%s:%d: duplicate user %s", fn, i, parts[0])
+		}
+		role := dav.RoleWrite
+		if len(parts) > 2 && parts[2] != "" {
+			if role, err = dav.ParseRole(parts[2]); err != nil {
+				return nil, fmt.Errorf("%s:%d: %s", fn, i, err)
+			}
+		}
+		u := dav.NewUser(parts[0], parts[1], role)
+		if len(parts) > 3 {
+			for _, rs := range strings.Split(parts[3], ",") {
+				r, err := dav.ParseRule(strings.TrimSpace(rs))
+				if err != nil {
+					return nil, fmt.Errorf("%s:%d: %s", fn, i, err)
+				}
+				u.Rules = append(u.Rules, r)
+			}
+		}
+		users[parts[0]] = u
+	}
+	return users, sc.Err()
+}
+
+// configUsers returns users of the configuration and its users file, nil
+// when there is none.
+func configUsers(c *config.Config) (map[string]*dav.User, error) {
+	var users map[string]*dav.User
+	if c.UsersFile != "" {
+		var err error
+		if users, err = loadUsers(c.UsersFile); err != nil {
+			return nil, err
+		}
+	}
+	if len(c.Users) > 0 && users == nil {
+		users = make(map[string]*dav.User)
+	}
+	for name, cu := range c.Users {
+		if _, dup := users[name]; dup {
+			return nil, fmt.Errorf("users.%s: also defined in %s", name, c.UsersFile)
+		}
+		// Validated with the configuration
+		role := dav.RoleWrite
+		if cu.Role != "" {
+			role, _ = dav.ParseRole(cu.Role)
+		}
+		u := dav.NewUser(name, cu.Password, role)
+		for _, rs := range cu.Rules {
+			r, _ := dav.ParseRule(rs)
+			u.Rules = append(u.Rules, r)
+		}
+		users[name] = u
+	}
+	return users, nil
+}
+
+// userRoot is where user data lives, the data root itself when running with
+// a single SECRET.
+func userRoot(root, username string) string {
+	if username == "" {
+		return root
+	}
+	return filepath.Join(root, usersDir, username)
+}
+
+// appPasswords returns the app passwords of username.
+func appPasswords(root, username string) *auth.AppPasswords {
+	return auth.NewAppPasswords(filepath.Join(userRoot(root, username), auth.AppPasswordsFile))
+}
+
+// tenant is what a user gets: its own collections, served over WebDAV.
+type tenant struct {
+	lib *store.Collections
+	dav *webdav.Handler
+}
+
+// tenants lazily opens user data the first time they log in.
+type tenants struct {
+	mu    sync.Mutex
+	root  string
+	guard store.Guard
+	urls  *urlnorm.Normalizer
+	fixes store.Fixes
+	dedup dedup.Policy
+	m     map[string]*tenant
+}
+
+func newTenants(conf *config.Config) *tenants {
+	return &tenants{
+		root:  conf.Root,
+		guard: guard(conf),
+		urls:  urls(conf),
+		fixes: fixes(conf),
+		dedup: dedupPolicy(conf),
+		m:     make(map[string]*tenant),
+	}
+}
+
+func (t *tenants) get(username string) (*tenant, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if tn, ok := t.m[username]; ok {
+		return tn, nil
+	}
+	dir := userRoot(t.root, username)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	lib := store.NewCollections(dir, t.guard, t.urls)
+	lib.Fixes = t.fixes
+	lib.Dedup = t.dedup
+	lib.Allowed = func(r *http.Request, collection string, write bool) bool {
+		u := dav.UserFrom(r.Context())
+		return u != nil && u.Allowed("/"+collection+".xbel", !write)
+	}
+	lib.CSRF = func(r *http.Request) string {
+		return dav.CSRFToken(r.Context())
+	}
+	tn := &tenant{
+		lib: lib,
+		dav: &webdav.Handler{
+			FileSystem: vfs.NewVFS(library{lib}), // os.FS cannot be used here :(
+			LockSystem: webdav.NewMemLS(),
+			Logger: func(r *http.Request, err error) {
+				if err != nil {
+					logging.From(r.Context()).Warn("webdav error", zap.String("method", r.Method), zap.String("path", r.URL.Path), zap.Error(err))
+				}
+			},
+		},
+	}
+	t.m[username] = tn
+	return tn, nil
+}
+
+// each calls f with the collections of every tenant, sorted by username.
+func (t *tenants) each(f func(username string, lib *store.Collections)) {
+	t.mu.Lock()
+	names := make([]string, 0, len(t.m))
+	for n := range t.m {
+		names = append(names, n)
+	}
+	m := t.m
+	t.mu.Unlock()
+	sort.Strings(names)
+	for _, n := range names {
+		t.mu.Lock()
+		tn := m[n]
+		t.mu.Unlock()
+		f(n, tn.lib)
+	}
+}
+
+// close closes stores of every tenant, waiting for writes in progress.
+func (t *tenants) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, tn := range t.m {
+		tn.lib.Close()
+	}
+}
+
+// davConfig sets up authentication against users, or the shared secret when
+// there are none, each user being served its own tenant.
+func davConfig(tn *tenants, users map[string]*dav.User, c *config.Config) (*dav.Config, error) {
+	limiter := auth.NewLimiter()
+	limiter.Free = c.LoginLimit.Free
+	limiter.Base = c.LoginLimit.Lockout.Duration
+	limiter.Max = c.LoginLimit.MaxLockout.Duration
+	key, err := auth.LoadKey(filepath.Join(c.Root, auth.KeyFile))
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := auth.LoadSessions(key, filepath.Join(c.Root, auth.EndedFile))
+	if err != nil {
+		return nil, err
+	}
+	sessions.MaxAge = c.Sessions.MaxAge.Duration
+	cfg := &dav.Config{
+		Auth:     true,
+		Users:    make(map[string]*dav.User),
+		Limiter:  limiter,
+		Audit:    auth.NewAudit(filepath.Join(c.Root, auth.AuditFile)),
+		Sessions: sessions,
+		Tokens:   auth.NewTokens(filepath.Join(c.Root, auth.TokensFile)),
+	}
+	if len(c.Cors) > 0 {
+		cfg.Cors = dav.NewCors(c.Cors...)
+	}
+
+	if users == nil {
+		t, err := tn.get("")
+		if err != nil {
+			return nil, err
+		}
+		cfg.User = dav.NewUser("", c.Secret, dav.RoleAdmin)
+		cfg.User.Handler = t.dav
+		cfg.User.AppPasswords = appPasswords(c.Root, "")
+		return cfg, nil
+	}
+
+	if len(users) == 0 {
+		return nil, fmt.Errorf("no user defined")
+	}
+	cfg.User = &dav.User{}
+	for username, u := range users {
+		t, err := tn.get(username)
+		if err != nil {
+			return nil, err
+		}
+		u.Handler = t.dav
+		u.AppPasswords = appPasswords(c.Root, username)
+		cfg.Users[username] = u
+	}
+	return cfg, nil
+}
@@ -0,0 +1,232 @@
+package vfs
+
+import (
+	"context"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	dav "github.com/dav-m85/xbellum/webdav"
+	"golang.org/x/net/webdav"
+)
+
+// Read-only collections exposing past revisions, with one directory per
+// bookmark collection. /history/<collection>/ lists every revision under its
+// store id, /tags/<collection>/ lists named ones as <tag>.xbel.
+const (
+	historyDir = "/history"
+	tagsDir    = "/tags"
+
+	readOnlyDir  = os.ModeDir | 0555
+	readOnlyFile = 0444
+)
+
+func isHistory(name string) bool {
+	for _, dir := range []string{historyDir, tagsDir} {
+		if name == dir || strings.HasPrefix(name, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// splitHistory splits /history/work/bkm_000001.xbel into its top directory,
+// collection and file name. Missing parts are left empty.
+func splitHistory(name string) (top, collection, file string, ok bool) {
+	parts := strings.SplitN(strings.TrimPrefix(name, "/"), "/", 3)
+	top = "/" + parts[0]
+	if len(parts) > 1 {
+		collection = parts[1]
+	}
+	if len(parts) > 2 {
+		file = parts[2]
+		if strings.Contains(file, "/") {
+			return "", "", "", false
+		}
+	}
+	return top, collection, file, true
+}
+
+// historyEntry is a file of a read-only collection, pointing to a revision.
+type historyEntry struct {
+	id   string
+	info *memFileInfo
+}
+
+// readable tells if the user found in ctx, if any, may read collection. Path
+// rules only see /<collection>.xbel, so they are applied here to its history
+// and tags as well.
+func readable(ctx context.Context, collection string) bool {
+	u := dav.UserFrom(ctx)
+	return u == nil || u.Allowed("/"+collection+xbelExt, true)
+}
+
+// collections lists the collections readable within ctx.
+func (fs *VFS) collections(ctx context.Context) []string {
+	var names []string
+	for _, n := range fs.lib.Names() {
+		if readable(ctx, n) {
+			names = append(names, n)
+		}
+	}
+	return names
+}
+
+func (fs *VFS) hasCollection(ctx context.Context, name string) bool {
+	for _, n := range fs.collections(ctx) {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// listHistory returns the entries of a collection directory within top,
+// sorted by name.
+func (fs *VFS) listHistory(ctx context.Context, top, collection string) ([]historyEntry, error) {
+	if !fs.hasCollection(ctx, collection) {
+		return nil, os.ErrNotExist
+	}
+	st, err := fs.lib.Open(collection, false)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := st.Revisions()
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]os.FileInfo, len(infos))
+	for _, fi := range infos {
+		byID[fi.Name()] = fi
+	}
+
+	ids := make(map[string]string)
+	if top == historyDir {
+		for id := range byID {
+			ids[id] = id
+		}
+	} else {
+		for tag, id := range st.Tags() {
+			ids[tag+xbelExt] = id
+		}
+	}
+
+	var entries []historyEntry
+	for name, id := range ids {
+		fi, ok := byID[id]
+		if !ok {
+			continue
+		}
+		entries = append(entries, historyEntry{
+			id: id,
+			info: &memFileInfo{
+				name:    name,
+				size:    fi.Size(),
+				mode:    readOnlyFile,
+				modTime: fi.ModTime(),
+			},
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].info.name < entries[j].info.name
+	})
+	return entries, nil
+}
+
+// findHistory looks up the entry for a file within a collection directory.
+func (fs *VFS) findHistory(ctx context.Context, top, collection, file string) (historyEntry, error) {
+	entries, err := fs.listHistory(ctx, top, collection)
+	if err != nil {
+		return historyEntry{}, err
+	}
+	for _, e := range entries {
+		if e.info.name == file {
+			return e, nil
+		}
+	}
+	return historyEntry{}, os.ErrNotExist
+}
+
+func dirInfo(name string) *memFileInfo {
+	return &memFileInfo{
+		name:    name,
+		mode:    readOnlyDir,
+		modTime: time.Now(),
+	}
+}
+
+func (fs *VFS) statHistory(ctx context.Context, name string) (os.FileInfo, error) {
+	top, collection, file, ok := splitHistory(name)
+	switch {
+	case !ok:
+		return nil, os.ErrNotExist
+	case collection == "":
+		return dirInfo(path.Base(top)), nil
+	case file == "":
+		if !fs.hasCollection(ctx, collection) {
+			return nil, os.ErrNotExist
+		}
+		return dirInfo(collection), nil
+	}
+	e, err := fs.findHistory(ctx, top, collection, file)
+	if err != nil {
+		return nil, err
+	}
+	return e.info, nil
+}
+
+func (fs *VFS) openHistory(ctx context.Context, name string, flag int) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, os.ErrPermission
+	}
+
+	top, collection, file, ok := splitHistory(name)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	if file == "" {
+		var children []os.FileInfo
+		if collection == "" {
+			for _, n := range fs.collections(ctx) {
+				children = append(children, dirInfo(n))
+			}
+		} else {
+			entries, err := fs.listHistory(ctx, top, collection)
+			if err != nil {
+				return nil, err
+			}
+			for _, e := range entries {
+				children = append(children, e.info)
+			}
+		}
+		return &memFile{
+			n:                &memFSNode{mode: readOnlyDir, modTime: time.Now()},
+			nameSnapshot:     path.Base(name),
+			childrenSnapshot: children,
+		}, nil
+	}
+
+	e, err := fs.findHistory(ctx, top, collection, file)
+	if err != nil {
+		return nil, err
+	}
+	st, err := fs.lib.Open(collection, false)
+	if err != nil {
+		return nil, err
+	}
+	data, err := st.Revision(e.id)
+	if err != nil {
+		return nil, err
+	}
+	return &memFile{
+		n: &memFSNode{
+			data:    data,
+			mode:    readOnlyFile,
+			modTime: e.info.modTime,
+		},
+		nameSnapshot: e.info.name,
+	}, nil
+}
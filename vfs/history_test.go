@@ -0,0 +1,104 @@
+package vfs
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	dav "github.com/dav-m85/xbellum/webdav"
+	"github.com/matryer/is"
+	"golang.org/x/net/webdav"
+)
+
+func TestHistory(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	fs := NewVFS(newFakeLibrary())
+
+	// Both collections show up at the root
+	root, err := fs.OpenFile(ctx, "/", os.O_RDONLY, 0)
+	is.NoErr(err)
+	fis, err := root.Readdir(0)
+	is.NoErr(err)
+	names := map[string]bool{}
+	for _, fi := range fis {
+		names[fi.Name()] = fi.IsDir()
+	}
+	is.True(names["history"])
+	is.True(names["tags"])
+
+	// One directory per collection
+	dir, err := fs.OpenFile(ctx, "/history", os.O_RDONLY, 0)
+	is.NoErr(err)
+	fis, err = dir.Readdir(0)
+	is.NoErr(err)
+	is.Equal(len(fis), 1)
+	is.Equal(fis[0].Name(), "bookmarks")
+	is.True(fis[0].IsDir())
+
+	dir, err = fs.OpenFile(ctx, "/history/bookmarks", os.O_RDONLY, 0)
+	is.NoErr(err)
+	fis, err = dir.Readdir(0)
+	is.NoErr(err)
+	is.Equal(len(fis), 2)
+	is.Equal(fis[0].Name(), "bkm_000000.xbel")
+
+	f, err := fs.OpenFile(ctx, "/tags/bookmarks/before-cleanup.xbel", os.O_RDONLY, 0)
+	is.NoErr(err)
+	b, err := ioutil.ReadAll(f)
+	is.NoErr(err)
+	is.Equal(string(b), "first")
+
+	fi, err := fs.Stat(ctx, "/history/bookmarks/bkm_000001.xbel")
+	is.NoErr(err)
+	is.Equal(fi.Size(), int64(len("second")))
+
+	_, err = fs.Stat(ctx, "/history/bookmarks/nope.xbel")
+	is.True(os.IsNotExist(err))
+
+	// Nothing can be written there
+	_, err = fs.OpenFile(ctx, "/history/bookmarks/bkm_000001.xbel", os.O_RDWR|os.O_TRUNC, 0)
+	is.Equal(err, os.ErrPermission)
+	_, err = fs.OpenFile(ctx, "/tags/bookmarks/new.xbel", os.O_RDWR|os.O_CREATE, 0666)
+	is.Equal(err, os.ErrPermission)
+	is.Equal(fs.RemoveAll(ctx, "/history"), os.ErrPermission)
+}
+
+func TestHistoryRules(t *testing.T) {
+	is := is.New(t)
+	lib := newFakeLibrary()
+	lib.Open("work", true)
+	lib["work"].Set(context.Background(), []byte("secret"))
+
+	u := dav.NewUser("bob", "", dav.RoleWrite)
+	r, err := dav.ParseRule("/work.xbel=none")
+	is.NoErr(err)
+	u.Rules = append(u.Rules, r)
+
+	h := &webdav.Handler{FileSystem: NewVFS(lib), LockSystem: webdav.NewMemLS()}
+	do := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Depth", "1")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req.WithContext(dav.WithUser(req.Context(), u)))
+		return w
+	}
+
+	is.Equal(do("PROPFIND", "/history/work/").Code, http.StatusNotFound)
+	is.Equal(do("PROPFIND", "/tags/work/").Code, http.StatusNotFound)
+	is.Equal(do("GET", "/history/work/bkm_000000.xbel").Code, http.StatusNotFound)
+	is.Equal(do("PROPFIND", "/history/bookmarks/").Code, http.StatusMultiStatus)
+
+	// Hidden from listings too
+	w := do("PROPFIND", "/history/")
+	is.Equal(w.Code, http.StatusMultiStatus)
+	is.True(!strings.Contains(w.Body.String(), "work"))
+	w = do("PROPFIND", "/")
+	is.Equal(w.Code, http.StatusMultiStatus)
+	is.True(!strings.Contains(w.Body.String(), "work.xbel"))
+	is.True(strings.Contains(w.Body.String(), "bookmarks.xbel"))
+}
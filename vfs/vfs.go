@@ -3,49 +3,129 @@ package vfs
 import (
 	"context"
 	"errors"
-	"log"
 	"os"
 	"path"
+	"regexp"
 	"runtime"
 	"sync"
 	"time"
 
+	"github.com/dav-m85/xbellum/logging"
+	"github.com/dav-m85/xbellum/metrics"
+	"go.uber.org/zap"
 	"golang.org/x/net/webdav"
 )
 
+var uploads = metrics.Default.NewHistogram("xbellum_upload_bytes", "Sizes of uploaded collections.",
+	[]float64{1 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20})
+
 type Store interface {
-	Get() ([]byte, error)
-	Set([]byte) error
+	// Set records a new revision, ctx carrying the request ID.
+	Set(ctx context.Context, data []byte) error
+	// Head returns the latest revision id, empty when there is none.
+	Head() string
+	// Revisions lists revision files, oldest first.
+	Revisions() ([]os.FileInfo, error)
+	Revision(id string) ([]byte, error)
+	// Tags maps tag names to revision ids.
+	Tags() map[string]string
+}
+
+// Library gives access to bookmark collections, each being exposed as a
+// <name>.xbel file at the root.
+type Library interface {
+	// Names lists existing collections.
+	Names() []string
+	// Open returns the store of collection name, creating it when create is
+	// set.
+	Open(name string, create bool) (Store, error)
 }
 
 var _ webdav.FileSystem = &VFS{}
 
 type VFS struct {
-	mu   sync.Mutex
-	xbel *memFSNode
-	lock *memFSNode
-	set  func([]byte) error
+	mu sync.Mutex
+	// files holds the xbel files and their floccus lock, by base name.
+	files map[string]*memFSNode
+	lib   Library
 }
 
 // NewMemFS returns a new in-memory FileSystem implementation.
-func NewVFS(r Store) webdav.FileSystem {
-	vfs := &VFS{set: r.Set}
-	res, err := r.Get()
-	if err == nil {
-		vfs.xbel = &memFSNode{
-			mode: 0,
-			data: res,
-		}
+func NewVFS(lib Library) webdav.FileSystem {
+	vfs := &VFS{
+		files: make(map[string]*memFSNode),
+		lib:   lib,
 	}
+	vfs.refreshAll()
 
 	return vfs
 }
 
+// refresh loads the head revision of collection into its file, unless the
+// file already holds it. Stores can get new revisions behind our back, a
+// restore from the web UI for instance.
+func (fs *VFS) refresh(collection string) error {
+	st, err := fs.lib.Open(collection, false)
+	if err != nil {
+		// Not created yet
+		return nil
+	}
+	head := st.Head()
+	if head == "" {
+		return nil
+	}
+	n := fs.files[collection+xbelExt]
+	if n != nil {
+		n.mu.Lock()
+		rev := n.rev
+		n.mu.Unlock()
+		if rev == head {
+			return nil
+		}
+	}
+	data, err := st.Revision(head)
+	if err != nil {
+		return err
+	}
+	if n == nil {
+		n = &memFSNode{}
+		fs.files[collection+xbelExt] = n
+	}
+	n.mu.Lock()
+	n.data, n.rev, n.modTime = data, head, time.Now()
+	n.mu.Unlock()
+	return nil
+}
+
+func (fs *VFS) refreshAll() {
+	for _, name := range fs.lib.Names() {
+		if err := fs.refresh(name); err != nil {
+			zap.L().Warn("refresh failed", zap.String("collection", name), zap.Error(err))
+		}
+	}
+}
+
+const xbelExt = ".xbel"
+
+var fileReg = regexp.MustCompile(`^/([A-Za-z0-9_-]+)\.xbel(\.lock)?$`)
+
+// parseName splits a root file name like /work.xbel or /work.xbel.lock into
+// its base name and collection. ok is false for any other name.
+func parseName(name string) (base, collection string, lock, ok bool) {
+	m := fileReg.FindStringSubmatch(name)
+	if m == nil {
+		return "", "", false, false
+	}
+	return name[1:], m[1], m[2] != "", true
+}
+
 type memFSNode struct {
 	mu      sync.Mutex
 	data    []byte
 	mode    os.FileMode
 	modTime time.Time
+	// rev is the store revision data comes from, if any.
+	rev string
 
 	// children is protected by memFS.mu.
 	children map[string]*memFSNode
@@ -62,14 +142,17 @@ func (n *memFSNode) stat(name string) *memFileInfo {
 	}
 }
 
-func (fs *VFS) root() *memFSNode {
+func (fs *VFS) root(ctx context.Context) *memFSNode {
+	fs.refreshAll()
 	cn := make(map[string]*memFSNode)
-	if fs.xbel != nil {
-		cn["bookmarks.xbel"] = fs.xbel
-	}
-	if fs.lock != nil {
-		cn["bookmarks.xbel.lock"] = fs.lock
+	for name, n := range fs.files {
+		if _, collection, _, ok := parseName("/" + name); ok && !readable(ctx, collection) {
+			continue
+		}
+		cn[name] = n
 	}
+	cn[path.Base(historyDir)] = &memFSNode{mode: readOnlyDir}
+	cn[path.Base(tagsDir)] = &memFSNode{mode: readOnlyDir}
 
 	return &memFSNode{
 		children: cn,
@@ -88,13 +171,15 @@ func (fs *VFS) root() *memFSNode {
 //   If latest same, replace it (dont recap for shuffling favs)
 
 func (fs *VFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
-	log.Print("OpenFile ", name)
+	logging.From(ctx).Debug("open file", zap.String("name", name), zap.Int("flag", flag))
 
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
 	var frag string
 	var n *memFSNode
+	var collection string
+	var lock bool
 	if name == "" || name == "/" {
 		// We're opening the root.
 		if runtime.GOOS == "zos" {
@@ -107,17 +192,23 @@ func (fs *VFS) OpenFile(ctx context.Context, name string, flag int, perm os.File
 			}
 		}
 
-		n, frag = fs.root(), "/"
+		n, frag = fs.root(ctx), "/"
 
+	} else if isHistory(name) {
+		return fs.openHistory(ctx, name, flag)
 	} else {
-		switch name {
-		case "/bookmarks.xbel":
-			n = fs.xbel
-		case "/bookmarks.xbel.lock":
-			n = fs.lock
-		default:
+		var base string
+		var ok bool
+		base, collection, lock, ok = parseName(name)
+		if !ok {
 			return nil, os.ErrInvalid
 		}
+		if !lock {
+			if err := fs.refresh(collection); err != nil {
+				return nil, err
+			}
+		}
+		n = fs.files[base]
 
 		if flag&(os.O_SYNC|os.O_APPEND) != 0 {
 			// memFile doesn't support these flags yet.
@@ -131,12 +222,7 @@ func (fs *VFS) OpenFile(ctx context.Context, name string, flag int, perm os.File
 				n = &memFSNode{
 					mode: perm.Perm(),
 				}
-				switch name {
-				case "/bookmarks.xbel":
-					fs.xbel = n
-				case "/bookmarks.xbel.lock":
-					fs.lock = n
-				}
+				fs.files[base] = n
 			}
 		}
 		if n == nil {
@@ -155,11 +241,26 @@ func (fs *VFS) OpenFile(ctx context.Context, name string, flag int, perm os.File
 	}
 	onClose := func(*memFile) error { return nil }
 
-	if name == "/bookmarks.xbel" {
+	if collection != "" && !lock {
 		onClose = func(f *memFile) error {
-			if f.written {
-				return fs.set(f.n.data)
+			if !f.written {
+				return nil
+			}
+			uploads.Observe(float64(len(f.n.data)))
+			st, err := fs.lib.Open(collection, true)
+			if err != nil {
+				return err
+			}
+			if err := st.Set(ctx, f.n.data); err != nil {
+				// Serve head again instead of the rejected data
+				f.n.mu.Lock()
+				f.n.rev = ""
+				f.n.mu.Unlock()
+				return err
 			}
+			f.n.mu.Lock()
+			f.n.rev = st.Head()
+			f.n.mu.Unlock()
 			return nil
 		}
 	}
@@ -173,7 +274,7 @@ func (fs *VFS) OpenFile(ctx context.Context, name string, flag int, perm os.File
 }
 
 func (fs *VFS) RemoveAll(ctx context.Context, name string) error {
-	log.Print("RemoveAll ", name)
+	logging.From(ctx).Debug("remove", zap.String("name", name))
 
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
@@ -182,38 +283,43 @@ func (fs *VFS) RemoveAll(ctx context.Context, name string) error {
 		// We can't remove the root.
 		return os.ErrInvalid
 	}
-	switch name {
-	case "/bookmarks.xbel":
-		fs.xbel = nil
-	case "/bookmarks.xbel.lock":
-		fs.lock = nil
-	default:
+	if isHistory(name) {
+		return os.ErrPermission
+	}
+	base, _, lock, ok := parseName(name)
+	if !ok {
 		return os.ErrInvalid
 	}
+	if !lock {
+		// Collections live in the store, they would be back on next read.
+		return os.ErrPermission
+	}
+	delete(fs.files, base)
 	return nil
 }
 
 func (fs *VFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
-	log.Print("Stat ", name)
+	logging.From(ctx).Debug("stat", zap.String("name", name))
 
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
 	if name == "" || name == "/" {
 		// We're stat'ting the root.
-		return fs.root().stat("/"), nil
+		return fs.root(ctx).stat("/"), nil
 	}
-	switch name {
-	case "/bookmarks.xbel":
-		if fs.xbel == nil {
-			return nil, os.ErrNotExist
+	if isHistory(name) {
+		return fs.statHistory(ctx, name)
+	}
+	if base, collection, lock, ok := parseName(name); ok {
+		if !lock {
+			if err := fs.refresh(collection); err != nil {
+				return nil, err
+			}
 		}
-		return fs.xbel.stat(path.Base(name)), nil
-	case "/bookmarks.xbel.lock":
-		if fs.lock == nil {
-			return nil, os.ErrNotExist
+		if n, exists := fs.files[base]; exists {
+			return n.stat(base), nil
 		}
-		return fs.lock.stat(path.Base(name)), nil
 	}
 
 	return nil, os.ErrNotExist
@@ -222,11 +328,11 @@ func (fs *VFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
 var ErrNotImplemented = errors.New("not implemented")
 
 func (v *VFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
-	log.Print("Mkdir ", name)
+	logging.From(ctx).Debug("mkdir", zap.String("name", name))
 	return ErrNotImplemented
 }
 
 func (v *VFS) Rename(ctx context.Context, oldName, newName string) error {
-	log.Print("Rename ", oldName)
+	logging.From(ctx).Debug("rename", zap.String("from", oldName), zap.String("to", newName))
 	return ErrNotImplemented
 }
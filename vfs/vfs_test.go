@@ -0,0 +1,177 @@
+package vfs
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/dav-m85/xbellum/logging"
+	"github.com/matryer/is"
+)
+
+// fakeStore keeps revisions in memory, the last one being head.
+type fakeStore struct {
+	ids  []string
+	revs map[string][]byte
+	tags map[string]string
+	// requestID is the one of the last Set
+	requestID string
+}
+
+func (s *fakeStore) Head() string {
+	if len(s.ids) == 0 {
+		return ""
+	}
+	return s.ids[len(s.ids)-1]
+}
+
+func (s *fakeStore) Set(ctx context.Context, d []byte) error {
+	id := fmt.Sprintf("bkm_%06d.xbel", len(s.ids))
+	s.ids = append(s.ids, id)
+	s.revs[id] = d
+	s.requestID = logging.RequestID(ctx)
+	return nil
+}
+
+func (s *fakeStore) Revisions() ([]os.FileInfo, error) {
+	var infos []os.FileInfo
+	for _, id := range s.ids {
+		infos = append(infos, &memFileInfo{name: id, size: int64(len(s.revs[id])), modTime: time.Now()})
+	}
+	return infos, nil
+}
+
+func (s *fakeStore) Revision(id string) ([]byte, error) {
+	d, ok := s.revs[id]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return d, nil
+}
+
+func (s *fakeStore) Tags() map[string]string { return s.tags }
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{revs: make(map[string][]byte), tags: make(map[string]string)}
+}
+
+// fakeLibrary starts with a bookmarks collection having two revisions.
+type fakeLibrary map[string]*fakeStore
+
+func (l fakeLibrary) Names() []string {
+	var names []string
+	for n, s := range l {
+		if len(s.ids) > 0 {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (l fakeLibrary) Open(name string, create bool) (Store, error) {
+	s, ok := l[name]
+	if !ok {
+		if !create {
+			return nil, os.ErrNotExist
+		}
+		s = newFakeStore()
+		l[name] = s
+	}
+	return s, nil
+}
+
+func newFakeLibrary() fakeLibrary {
+	s := newFakeStore()
+	s.Set(context.Background(), []byte("first"))
+	s.Set(context.Background(), []byte("second"))
+	s.tags["before-cleanup"] = "bkm_000000.xbel"
+	return fakeLibrary{"bookmarks": s}
+}
+
+func TestCollections(t *testing.T) {
+	is := is.New(t)
+	ctx := logging.WithRequestID(context.Background(), "req1")
+	lib := newFakeLibrary()
+	fs := NewVFS(lib)
+
+	_, err := fs.Stat(ctx, "/work.xbel")
+	is.True(os.IsNotExist(err))
+	_, err = fs.OpenFile(ctx, "/notes.txt", os.O_RDONLY, 0)
+	is.Equal(err, os.ErrInvalid)
+
+	// First PUT creates the collection
+	f, err := fs.OpenFile(ctx, "/work.xbel", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	is.NoErr(err)
+	_, err = f.Write([]byte("work"))
+	is.NoErr(err)
+	is.NoErr(f.Close())
+	is.Equal(lib.Names(), []string{"bookmarks", "work"})
+	is.Equal(lib["work"].revs["bkm_000000.xbel"], []byte("work"))
+	is.Equal(lib["work"].requestID, "req1")
+
+	// Lock files are not collections
+	f, err = fs.OpenFile(ctx, "/work.xbel.lock", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	is.NoErr(err)
+	_, err = f.Write([]byte("lock"))
+	is.NoErr(err)
+	is.NoErr(f.Close())
+	is.Equal(len(lib["work"].ids), 1)
+
+	root, err := fs.OpenFile(ctx, "/", os.O_RDONLY, 0)
+	is.NoErr(err)
+	fis, err := root.Readdir(0)
+	is.NoErr(err)
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	sort.Strings(names)
+	is.Equal(names, []string{"bookmarks.xbel", "history", "tags", "work.xbel", "work.xbel.lock"})
+
+	f, err = fs.OpenFile(ctx, "/bookmarks.xbel", os.O_RDONLY, 0)
+	is.NoErr(err)
+	b, err := ioutil.ReadAll(f)
+	is.NoErr(err)
+	is.Equal(string(b), "second")
+
+	// Collections cannot be deleted, lock files can
+	is.Equal(fs.RemoveAll(ctx, "/work.xbel"), os.ErrPermission)
+	_, err = fs.Stat(ctx, "/work.xbel")
+	is.NoErr(err)
+	is.NoErr(fs.RemoveAll(ctx, "/work.xbel.lock"))
+	_, err = fs.Stat(ctx, "/work.xbel.lock")
+	is.True(os.IsNotExist(err))
+}
+
+func TestRefresh(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	lib := newFakeLibrary()
+	fs := NewVFS(lib)
+
+	// A revision recorded behind the VFS back, like a restore
+	lib["bookmarks"].Set(context.Background(), []byte("first"))
+
+	fi, err := fs.Stat(ctx, "/bookmarks.xbel")
+	is.NoErr(err)
+	is.Equal(fi.Size(), int64(len("first")))
+	f, err := fs.OpenFile(ctx, "/bookmarks.xbel", os.O_RDONLY, 0)
+	is.NoErr(err)
+	b, err := ioutil.ReadAll(f)
+	is.NoErr(err)
+	is.Equal(string(b), "first")
+
+	// Our own writes are not read back
+	f, err = fs.OpenFile(ctx, "/bookmarks.xbel", os.O_RDWR|os.O_TRUNC, 0)
+	is.NoErr(err)
+	_, err = f.Write([]byte("third"))
+	is.NoErr(err)
+	is.NoErr(f.Close())
+	is.Equal(len(lib["bookmarks"].ids), 4)
+	is.Equal(fs.(*VFS).files["bookmarks.xbel"].rev, "bkm_000003.xbel")
+}
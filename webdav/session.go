@@ -0,0 +1,175 @@
+package webdav
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/dav-m85/xbellum/auth"
+	"github.com/dav-m85/xbellum/logging"
+	"github.com/dav-m85/xbellum/proxy"
+	"go.uber.org/zap"
+)
+
+const csrfKey ctxKey = 1
+
+// CSRFToken returns the token state-changing forms must carry in a csrf
+// field, empty outside of the UI middleware.
+func CSRFToken(ctx context.Context) string {
+	t, _ := ctx.Value(csrfKey).(string)
+	return t
+}
+
+var loginTpl = template.Must(template.New("login").Parse(`
+<html>
+<head><meta name="viewport" content="width=device-width, initial-scale=1"></head>
+<body>
+{{if .Error}}<p style="color: red">{{.Error}}</p>{{end}}
+<form method="post" action="{{.Prefix}}/login">
+	<input type="hidden" name="next" value="{{.Next}}">
+	<p><input type="text" name="username" placeholder="Username" value="{{.Username}}" autocomplete="username" autofocus></p>
+	<p><input type="password" name="password" placeholder="Password" autocomplete="current-password"></p>
+	<p><input type="submit" value="Log in"></p>
+</form>
+</body>
+</html>
+`))
+
+// UI returns a middleware for the web UI. Browsers log in with a form on
+// /login and get a session cookie, ended by posting to /logout, while Basic
+// auth keeps working for scripts. Requests other than GET and HEAD must
+// carry the CSRFToken in a csrf form field or X-CSRF-Token header, unless
+// authenticated with an API token.
+//
+// Without Sessions, it is the same as Handler.
+func (c *Config) UI(next http.Handler) http.Handler {
+	checked := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.checkCSRF(w, r, next, auth.Session{})
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.Sessions == nil || !c.Auth {
+			c.serve(w, r, next)
+			return
+		}
+		switch r.URL.Path {
+		case "/login":
+			c.serveLogin(w, r)
+			return
+		case "/logout":
+			c.serveLogout(w, r)
+			return
+		}
+
+		if _, ok := proxy.User(r); ok {
+			c.serve(w, r, checked)
+			return
+		}
+		// Browsers don't send tokens by themselves, no CSRF check needed
+		if _, ok := bearer(r); ok {
+			c.serve(w, r, next)
+			return
+		}
+
+		if sess, ok := c.Sessions.Get(r); ok {
+			if u := c.lookupNamed(sess.Username); u != nil {
+				if !allowed(r, u) {
+					http.Error(w, "Forbidden", http.StatusForbidden)
+					return
+				}
+				c.checkCSRF(w, r.WithContext(WithUser(r.Context(), u)), next, sess)
+				return
+			}
+		}
+		if _, _, ok := r.BasicAuth(); ok {
+			c.serve(w, r, checked)
+			return
+		}
+		http.Redirect(w, r, proxy.Prefix(r)+"/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+	})
+}
+
+// checkCSRF calls next when the request is safe or carries the token of sess,
+// sess being empty for Basic auth.
+func (c *Config) checkCSRF(w http.ResponseWriter, r *http.Request, next http.Handler, sess auth.Session) {
+	username := UserFrom(r.Context()).Username
+	if sess.ID != "" {
+		username = sess.Username
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		token := r.Header.Get("X-CSRF-Token")
+		if token == "" {
+			token = r.FormValue("csrf")
+		}
+		if !c.Sessions.CheckToken(username, sess.ID, token) {
+			http.Error(w, "Invalid or missing CSRF token", http.StatusForbidden)
+			return
+		}
+	}
+	ctx := context.WithValue(r.Context(), csrfKey, c.Sessions.Token(username, sess.ID))
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// localPath returns next if it is a path on this server, /info otherwise.
+// Paths don't include the proxy prefix.
+func localPath(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/info"
+	}
+	return next
+}
+
+func (c *Config) serveLogin(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Prefix, Next, Username, Error string
+	}{
+		Prefix: proxy.Prefix(r),
+		Next:   localPath(r.FormValue("next")),
+	}
+	if r.Method == http.MethodPost {
+		data.Username = r.FormValue("username")
+		u, err := c.Login(r, data.Username, r.FormValue("password"))
+		if err == nil {
+			// The name the user is known by, empty for the shared secret
+			if _, err := c.Sessions.New(w, r, u.Username); err != nil {
+				logging.From(r.Context()).Error("starting session failed", zap.Error(err))
+				http.Error(w, "Internal error", http.StatusInternalServerError)
+				return
+			}
+			http.Redirect(w, r, data.Prefix+data.Next, http.StatusSeeOther)
+			return
+		}
+		data.Error = "Wrong username or password"
+		status := http.StatusUnauthorized
+		if _, ok := err.(*LockedError); ok {
+			data.Error = "Too many failed logins, try again later"
+			status = http.StatusTooManyRequests
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+	} else if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := loginTpl.Execute(w, data); err != nil {
+		logging.From(r.Context()).Error("rendering failed", zap.Error(err))
+	}
+}
+
+func (c *Config) serveLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if sess, ok := c.Sessions.Get(r); ok {
+		if !c.Sessions.CheckToken(sess.Username, sess.ID, r.FormValue("csrf")) {
+			http.Error(w, "Invalid or missing CSRF token", http.StatusForbidden)
+			return
+		}
+		if err := c.Sessions.End(w, r, sess); err != nil {
+			logging.From(r.Context()).Error("session not remembered as ended", zap.Error(err))
+		}
+	}
+	http.Redirect(w, r, proxy.Prefix(r)+"/login", http.StatusSeeOther)
+}
@@ -0,0 +1,95 @@
+package webdav
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/dav-m85/xbellum/auth"
+	"github.com/dav-m85/xbellum/logging"
+	"github.com/dav-m85/xbellum/proxy"
+	"go.uber.org/zap"
+)
+
+var tokensTpl = template.Must(template.New("tokens").Parse(`
+<html>
+<body>
+<p><a href="{{.Prefix}}/info">Bookmarks</a></p>
+<h2>API tokens</h2>
+{{if .Error}}<p style="color: red">{{.Error}}</p>{{end}}
+{{if .Created}}
+<p>New token, copy it now as it won't be shown again:<br><code>{{.Created}}</code></p>
+{{end}}
+<table>
+<tr><th>Label</th><th>Scopes</th><th>Created</th><th></th></tr>
+{{range .Tokens}}
+<tr>
+	<td>{{.Label}}</td><td>{{range .Scopes}}{{.}} {{end}}</td><td>{{.Created.Format "2006-01-02 15:04"}}</td>
+	<td>{{if $.CanManage}}<form method="post">
+		<input type="hidden" name="csrf" value="{{$.CSRF}}">
+		<input type="hidden" name="revoke" value="{{.Label}}">
+		<input type="submit" value="Revoke">
+	</form>{{end}}</td>
+</tr>
+{{end}}
+</table>
+{{if .CanManage}}
+<form method="post">
+	<input type="hidden" name="csrf" value="{{.CSRF}}">
+	<input type="text" name="label" placeholder="Label, like ci">
+	{{range .Scopes}}<label><input type="checkbox" name="scope" value="{{.}}"> {{.}}</label>{{end}}
+	<input type="submit" value="Create">
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+// ServeTokens lets the user of the request list, create and revoke their API
+// tokens. Token users need the admin scope to manage tokens.
+func (c *Config) ServeTokens(w http.ResponseWriter, r *http.Request) {
+	u := UserFrom(r.Context())
+	if u == nil || c.Tokens == nil {
+		http.NotFound(w, r)
+		return
+	}
+	data := struct {
+		Prefix    string
+		Tokens    []auth.Token
+		Scopes    []string
+		CanManage bool
+		CSRF      string
+		Created   string
+		Error     string
+	}{
+		Prefix:    proxy.Prefix(r),
+		Scopes:    auth.Scopes,
+		CanManage: u.Can(auth.ScopeAdmin),
+		CSRF:      CSRFToken(r.Context()),
+	}
+
+	if r.Method == http.MethodPost {
+		if !data.CanManage {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		var err error
+		if label := r.FormValue("revoke"); label != "" {
+			err = c.Tokens.Revoke(u.Username, label)
+		} else {
+			data.Created, err = c.Tokens.Add(u.Username, r.FormValue("label"), r.Form["scope"])
+		}
+		if err != nil {
+			data.Error = err.Error()
+		}
+	}
+
+	var err error
+	if data.Tokens, err = c.Tokens.List(u.Username); err != nil {
+		logging.From(r.Context()).Error("listing tokens failed", zap.Error(err))
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	if err := tokensTpl.Execute(w, data); err != nil {
+		logging.From(r.Context()).Error("rendering failed", zap.Error(err))
+	}
+}
@@ -1,9 +1,11 @@
-package lib
+package webdav
 
 import (
+	"fmt"
 	"regexp"
 	"strings"
 
+	"github.com/dav-m85/xbellum/auth"
 	"golang.org/x/net/webdav"
 )
 
@@ -16,14 +18,136 @@ type Rule struct {
 	Regexp *regexp.Regexp
 }
 
+// ParseRule reads a rule written as path=access, access being one of none,
+// read or write. A path starting with ~ is a regular expression.
+func ParseRule(s string) (*Rule, error) {
+	i := strings.LastIndex(s, "=")
+	if i <= 0 {
+		return nil, fmt.Errorf("invalid rule %q, expected path=access", s)
+	}
+	r := &Rule{Path: s[:i]}
+	switch s[i+1:] {
+	case "none":
+	case "read":
+		r.Allow = true
+	case "write":
+		r.Allow, r.Modify = true, true
+	default:
+		return nil, fmt.Errorf("invalid rule %q, access must be none, read or write", s)
+	}
+	if strings.HasPrefix(r.Path, "~") {
+		re, err := regexp.Compile(r.Path[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule %q: %s", s, err)
+		}
+		r.Regex, r.Regexp = true, re
+	}
+	return r, nil
+}
+
+// Role is a set of permissions, each role granting the ones before it.
+type Role int
+
+const (
+	// RoleRead can browse and download bookmarks and their history.
+	RoleRead Role = iota
+	// RoleWrite can also upload and restore revisions.
+	RoleWrite
+	// RoleAdmin can also use server administration pages.
+	RoleAdmin
+)
+
+var roleNames = []string{"read", "write", "admin"}
+
+func (r Role) String() string {
+	if r < 0 || int(r) >= len(roleNames) {
+		return fmt.Sprintf("Role(%d)", int(r))
+	}
+	return roleNames[r]
+}
+
+func ParseRole(s string) (Role, error) {
+	for i, n := range roleNames {
+		if n == s {
+			return Role(i), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid role %q, expected one of %s", s, strings.Join(roleNames, ", "))
+}
+
+var usernameReg = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// ValidUsername tells if name can be used as a username. Usernames are used
+// as directory names.
+func ValidUsername(name string) bool {
+	return usernameReg.MatchString(name) && name != "." && name != ".."
+}
+
 // User contains the settings of each user.
 type User struct {
 	Username string
 	Password string
 	Scope    string
-	Modify   bool
-	Rules    []*Rule
-	Handler  *webdav.Handler
+	// Modify is the write permission on paths no rule matches, it goes with
+	// Role being at least RoleWrite.
+	Modify  bool
+	Role    Role
+	Rules   []*Rule
+	Handler *webdav.Handler
+	// AppPasswords are accepted in place of Password when set.
+	AppPasswords *auth.AppPasswords
+	// Scopes limit a user logged in with an API token, nil otherwise.
+	Scopes []string
+}
+
+// NewUser returns a user with the default permissions of role.
+func NewUser(username, password string, role Role) *User {
+	return &User{
+		Username: username,
+		Password: password,
+		Role:     role,
+		Modify:   role >= RoleWrite,
+	}
+}
+
+// Is tells if the user has at least role r.
+func (u User) Is(r Role) bool {
+	return u.Role >= r
+}
+
+// Can tells if the user scopes grant scope. Users without scopes can do
+// anything their role allows, every scope reads, write implies restore and
+// admin implies everything.
+func (u User) Can(scope string) bool {
+	if u.Scopes == nil || scope == auth.ScopeRead {
+		return true
+	}
+	for _, s := range u.Scopes {
+		if s == scope || s == auth.ScopeAdmin || (s == auth.ScopeWrite && scope == auth.ScopeRestore) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithScopes returns a copy of the user limited to scopes, lowering its role
+// and permissions to match.
+func (u *User) WithScopes(scopes []string) *User {
+	c := *u
+	c.Scopes = scopes
+	if !c.Can(auth.ScopeAdmin) && c.Role > RoleWrite {
+		c.Role = RoleWrite
+	}
+	if !c.Can(auth.ScopeRestore) {
+		c.Role, c.Modify = RoleRead, false
+		c.Rules = make([]*Rule, len(u.Rules))
+		for i, r := range u.Rules {
+			ro := *r
+			ro.Modify = false
+			c.Rules[i] = &ro
+		}
+	}
+	return &c
 }
 
 // Allowed checks if the user has permission to access a directory/file
@@ -1,10 +1,17 @@
-package lib
+package webdav
 
 import (
 	"context"
+	"errors"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/dav-m85/xbellum/auth"
+	"github.com/dav-m85/xbellum/logging"
+	"github.com/dav-m85/xbellum/metrics"
+	"github.com/dav-m85/xbellum/proxy"
 	"go.uber.org/zap"
 )
 
@@ -18,19 +25,242 @@ type CorsCfg struct {
 	ExposedHeaders []string
 }
 
+// NewCors returns an enabled CORS config for allowedHosts, allowing the
+// methods and headers WebDAV clients need, with credentials.
+func NewCors(allowedHosts ...string) CorsCfg {
+	return CorsCfg{
+		Enabled:        true,
+		Credentials:    true,
+		AllowedHeaders: []string{"Authorization", "Content-Type", "Depth", "Destination", "If", "Lock-Token", "Overwrite", "Timeout"},
+		AllowedHosts:   allowedHosts,
+		AllowedMethods: []string{"GET", "HEAD", "PUT", "DELETE", "OPTIONS", "PROPFIND", "PROPPATCH", "MKCOL", "COPY", "MOVE", "LOCK", "UNLOCK"},
+		ExposedHeaders: []string{"Content-Length", "DAV", "ETag", "Last-Modified", "Lock-Token"},
+	}
+}
+
 // Config is the configuration of a WebDAV instance.
+//
+// The embedded User is the default one. When authenticating with no Users,
+// any username is let in with the default User password.
 type Config struct {
 	*User
-	Auth      bool
-	Debug     bool
-	NoSniff   bool
-	Cors      CorsCfg
-	Users     map[string]*User
-	LogFormat string
+	Auth  bool
+	Cors  CorsCfg
+	Users map[string]*User
+	// Limiter, when set, locks out IPs and usernames failing to log in.
+	Limiter *auth.Limiter
+	// Audit, when set, records logins and failures.
+	Audit *auth.Audit
+	// Sessions, when set, lets UI users log in with a form.
+	Sessions *auth.Sessions
+	// Tokens, when set, are accepted as "Authorization: Bearer".
+	Tokens *auth.Tokens
+}
+
+// ErrUnauthorized is returned by Login for wrong credentials.
+var ErrUnauthorized = errors.New("not authorized")
+
+// LockedError is returned by Login when too many logins failed lately.
+type LockedError struct {
+	Wait time.Duration
+}
+
+func (e *LockedError) Error() string {
+	return "too many failed logins"
+}
+
+// Login checks credentials against users, or the default User when there
+// are none, recording the outcome in Limiter and Audit.
+func (c *Config) Login(r *http.Request, username, password string) (*User, error) {
+	ip := proxy.ClientIP(r)
+	if wait := c.locked(ip, username); wait > 0 {
+		c.audit(auth.EventLocked, username, ip, "")
+		return nil, &LockedError{Wait: wait}
+	}
+
+	user := c.lookup(username)
+	if user == nil {
+		c.failed(r, ip, username, "unknown user")
+		return nil, ErrUnauthorized
+	}
+
+	label := ""
+	if !auth.Check(user.Password, password) {
+		ok := false
+		if user.AppPasswords != nil {
+			label, ok = user.AppPasswords.Check(password)
+		}
+		if !ok {
+			c.failed(r, ip, username, "invalid password")
+			return nil, ErrUnauthorized
+		}
+		logging.From(r.Context()).Info("app password used", zap.String("username", username), zap.String("label", label))
+	}
+
+	if c.Limiter != nil {
+		c.Limiter.Success(ip)
+		c.Limiter.Success("user:" + username)
+	}
+	c.audit(auth.EventLogin, username, ip, label)
+	return user, nil
+}
+
+// LoginToken checks an API token, returning its user limited to the token
+// scopes.
+func (c *Config) LoginToken(r *http.Request, token string) (*User, error) {
+	ip := proxy.ClientIP(r)
+	if wait := c.locked(ip, ""); wait > 0 {
+		c.audit(auth.EventLocked, "", ip, "token")
+		return nil, &LockedError{Wait: wait}
+	}
+	var t auth.Token
+	ok := false
+	if c.Tokens != nil {
+		t, ok = c.Tokens.Check(token)
+	}
+	var user *User
+	if ok {
+		user = c.lookupNamed(t.Username)
+	}
+	if user == nil {
+		c.failed(r, ip, "", "invalid token")
+		return nil, ErrUnauthorized
+	}
+	if c.Limiter != nil {
+		c.Limiter.Success(ip)
+	}
+	c.audit(auth.EventLogin, t.Username, ip, "token "+t.Label)
+	return user.WithScopes(t.Scopes), nil
+}
+
+// LoginProxy returns the user a trusted proxy authenticated.
+func (c *Config) LoginProxy(r *http.Request, username string) (*User, error) {
+	ip := proxy.ClientIP(r)
+	user := c.lookupNamed(username)
+	if user == nil {
+		c.audit(auth.EventFailure, username, ip, "unknown proxy user")
+		return nil, ErrUnauthorized
+	}
+	c.audit(auth.EventLogin, username, ip, "proxy")
+	return user, nil
+}
+
+// bearer returns the token of an "Authorization: Bearer" header.
+func bearer(r *http.Request) (string, bool) {
+	h := r.Header.Get("Authorization")
+	if len(h) < 7 || !strings.EqualFold(h[:7], "bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(h[7:]), true
+}
+
+// lookup returns the user named username, nil if none. Without users, any
+// username gets the default User, Basic auth clients like floccus sending
+// whatever they were set up with.
+func (c *Config) lookup(username string) *User {
+	if len(c.Users) == 0 {
+		return c.User
+	}
+	return c.Users[username]
+}
+
+// lookupNamed is lookup for tokens, sessions and proxies, which name their
+// user themselves: without users, only the empty username gets the default
+// User.
+func (c *Config) lookupNamed(username string) *User {
+	if len(c.Users) == 0 && username != "" {
+		return nil
+	}
+	return c.lookup(username)
+}
+
+// deny answers a failed Login.
+func deny(w http.ResponseWriter, err error) {
+	if l, ok := err.(*LockedError); ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(l.Wait.Seconds())+1))
+		http.Error(w, "Too many failed logins", http.StatusTooManyRequests)
+		return
+	}
+	http.Error(w, "Not authorized", http.StatusUnauthorized)
+}
+
+// locked returns how long ip or username must wait before trying again.
+func (c *Config) locked(ip, username string) time.Duration {
+	if c.Limiter == nil {
+		return 0
+	}
+	wait := c.Limiter.Locked(ip)
+	if username == "" {
+		return wait
+	}
+	if w := c.Limiter.Locked("user:" + username); w > wait {
+		wait = w
+	}
+	return wait
+}
+
+var (
+	authFailures = metrics.Default.NewCounter("xbellum_auth_failures_total",
+		"Failed logins by reason.", "reason")
+	lockouts = metrics.Default.NewCounter("xbellum_auth_lockouts_total",
+		"Addresses and users locked out after failed logins.")
+)
+
+func (c *Config) failed(r *http.Request, ip, username, reason string) {
+	authFailures.Inc(reason)
+	l := logging.From(r.Context())
+	l.Info("login failed", zap.String("username", username), zap.String("remote_address", ip), zap.String("reason", reason))
+	if c.Limiter != nil {
+		if d := c.Limiter.Fail(ip); d > 0 {
+			lockouts.Inc()
+			l.Warn("address locked out", zap.String("remote_address", ip), zap.Duration("for", d))
+		}
+		// Tokens don't tell whose they are
+		if username != "" {
+			if d := c.Limiter.Fail("user:" + username); d > 0 {
+				lockouts.Inc()
+				l.Warn("user locked out", zap.String("username", username), zap.Duration("for", d))
+			}
+		}
+	}
+	c.audit(auth.EventFailure, username, ip, reason)
+}
+
+func (c *Config) audit(kind, username, ip, detail string) {
+	if c.Audit != nil {
+		c.Audit.Log(auth.Event{Kind: kind, Username: username, RemoteAddr: ip, Detail: detail})
+	}
+}
+
+type ctxKey int
+
+const userKey ctxKey = 0
+
+// WithUser returns a copy of ctx carrying u.
+func WithUser(ctx context.Context, u *User) context.Context {
+	return context.WithValue(ctx, userKey, u)
+}
+
+// UserFrom returns the user authenticated by a Config, nil if none.
+func UserFrom(ctx context.Context) *User {
+	u, _ := ctx.Value(userKey).(*User)
+	return u
 }
 
 // ServeHTTP determines if the request is for this plugin, and if all prerequisites are met.
 func (c *Config) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.serve(w, r, http.HandlerFunc(ServeDAV))
+}
+
+// Handler returns a middleware applying CORS, authentication and user rules
+// before calling next, which can get the user with UserFrom.
+func (c *Config) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.serve(w, r, next)
+	})
+}
+
+func (c *Config) serve(w http.ResponseWriter, r *http.Request, next http.Handler) {
 	u := c.User
 	requestOrigin := r.Header.Get("Origin")
 
@@ -73,28 +303,28 @@ func (c *Config) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if c.Auth {
 		w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
 
-		// Gets the correct user for this request.
-		username, password, ok := r.BasicAuth()
-		zap.L().Info("login attempt", zap.String("username", username), zap.String("remote_address", r.RemoteAddr))
-		if !ok {
-			http.Error(w, "Not authorized", 401)
-			return
-		}
-
-		user, ok := c.Users[username]
-		if !ok {
-			http.Error(w, "Not authorized", 401)
-			return
+		var user *User
+		var err error
+		if username, ok := proxy.User(r); ok {
+			user, err = c.LoginProxy(r, username)
+		} else if token, ok := bearer(r); ok {
+			user, err = c.LoginToken(r, token)
+		} else {
+			// Gets the correct user for this request.
+			username, password, ok := r.BasicAuth()
+			logging.From(r.Context()).Debug("login attempt", zap.String("username", username))
+			if !ok {
+				http.Error(w, "Not authorized", 401)
+				return
+			}
+			user, err = c.Login(r, username, password)
 		}
-
-		if !checkPassword(user.Password, password) {
-			zap.L().Info("invalid password", zap.String("username", username), zap.String("remote_address", r.RemoteAddr))
-			http.Error(w, "Not authorized", 401)
+		if err != nil {
+			deny(w, err)
 			return
 		}
-
 		u = user
-		zap.L().Info("user authorized", zap.String("username", username))
+		logging.From(r.Context()).Debug("user authorized", zap.String("username", u.Username))
 	} else {
 		// Even if Auth is disabled, we might want to get
 		// the user from the Basic Auth header. Useful for Caddy
@@ -107,19 +337,52 @@ func (c *Config) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Checks for user permissions relatively to this PATH.
-	noModification := r.Method == "GET" || r.Method == "HEAD" ||
-		r.Method == "OPTIONS" || r.Method == "PROPFIND"
+	if !allowed(r, u) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
 
-	allowed := u.Allowed(r.URL.Path, noModification)
+	next.ServeHTTP(w, r.WithContext(WithUser(r.Context(), u)))
+}
 
-	zap.L().Debug("allowed & method & path", zap.Bool("allowed", allowed), zap.String("method", r.Method), zap.String("path", r.URL.Path))
+// allowed checks for user permissions relatively to the request path.
+func allowed(r *http.Request, u *User) bool {
+	allowed := u.Allowed(r.URL.Path, !modifies(r))
 
-	if !allowed {
-		w.WriteHeader(http.StatusForbidden)
+	logging.From(r.Context()).Debug("access checked", zap.Bool("allowed", allowed), zap.String("method", r.Method), zap.String("path", r.URL.Path))
+	return allowed
+}
+
+// modifies tells if the request method changes anything.
+func modifies(r *http.Request) bool {
+	return r.Method != "GET" && r.Method != "HEAD" &&
+		r.Method != "OPTIONS" && r.Method != "PROPFIND"
+}
+
+// ServeDAV runs the WebDAV handler of the user found in the request context.
+func ServeDAV(w http.ResponseWriter, r *http.Request) {
+	u := UserFrom(r.Context())
+	if u == nil || u.Handler == nil {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
 		return
 	}
 
+	// Tokens need the write scope to upload
+	if modifies(r) && !u.Can(auth.ScopeWrite) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	h := u.Handler
+	// Behind a proxy stripping a prefix, put it back so that it appears in
+	// responses, the handler removing it again
+	if prefix := proxy.Prefix(r); prefix != "" {
+		ph := *h
+		ph.Prefix = prefix + h.Prefix
+		h = &ph
+		r.URL.Path = prefix + r.URL.Path
+	}
+
 	if r.Method == "HEAD" {
 		w = newResponseWriterNoBody(w)
 	}
@@ -131,8 +394,8 @@ func (c *Config) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	//		the collection, or something else altogether.
 	//
 	// Get, when applied to collection, will return the same as PROPFIND method.
-	if r.Method == "GET" && strings.HasPrefix(r.URL.Path, u.Handler.Prefix) {
-		info, err := u.Handler.FileSystem.Stat(context.TODO(), strings.TrimPrefix(r.URL.Path, u.Handler.Prefix))
+	if r.Method == "GET" && strings.HasPrefix(r.URL.Path, h.Prefix) {
+		info, err := h.FileSystem.Stat(r.Context(), strings.TrimPrefix(r.URL.Path, h.Prefix))
 		if err == nil && info.IsDir() {
 			r.Method = "PROPFIND"
 
@@ -144,7 +407,7 @@ func (c *Config) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// Runs the WebDAV.
 	//u.Handler.LockSystem = webdav.NewMemLS()
-	u.Handler.ServeHTTP(w, r)
+	h.ServeHTTP(w, r)
 }
 
 // responseWriterNoBody is a wrapper used to suprress the body of the response
@@ -0,0 +1,400 @@
+package webdav
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/dav-m85/xbellum/auth"
+	"github.com/dav-m85/xbellum/proxy"
+	"github.com/matryer/is"
+	"golang.org/x/net/webdav"
+)
+
+func newUser(name, password string) *User {
+	return &User{
+		Username: name,
+		Password: password,
+		Modify:   true,
+		Handler: &webdav.Handler{
+			FileSystem: webdav.NewMemFS(),
+			LockSystem: webdav.NewMemLS(),
+		},
+	}
+}
+
+func do(h http.Handler, method, path, user, password string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if user != "" || password != "" {
+		r.SetBasicAuth(user, password)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestAuth(t *testing.T) {
+	is := is.New(t)
+
+	alice := newUser("alice", "{bcrypt}$2a$04$nkXvUTZJ5PDedPpLDMU4Ee.UfIAormQ9/qBpCEbgsjeRj2CvMsBnO") // "pa"
+	bob := newUser("bob", "pb")
+	c := &Config{
+		User:  &User{},
+		Auth:  true,
+		Users: map[string]*User{"alice": alice, "bob": bob},
+	}
+
+	is.Equal(do(c, "PUT", "/a.txt", "", "", "x").Code, http.StatusUnauthorized)
+	is.Equal(do(c, "PUT", "/a.txt", "alice", "pb", "x").Code, http.StatusUnauthorized)
+	is.Equal(do(c, "PUT", "/a.txt", "carol", "pb", "x").Code, http.StatusUnauthorized)
+	is.Equal(do(c, "PUT", "/a.txt", "alice", "pa", "x").Code, http.StatusCreated)
+
+	// Users are isolated
+	is.Equal(do(c, "GET", "/a.txt", "alice", "pa", "").Code, http.StatusOK)
+	is.Equal(do(c, "GET", "/a.txt", "bob", "pb", "").Code, http.StatusNotFound)
+
+	// HEAD has no body, GET on a collection lists it
+	w := do(c, "HEAD", "/a.txt", "alice", "pa", "")
+	is.Equal(w.Code, http.StatusOK)
+	is.Equal(w.Body.Len(), 0)
+	w = do(c, "GET", "/", "alice", "pa", "")
+	is.Equal(w.Code, http.StatusMultiStatus)
+	is.True(strings.Contains(w.Body.String(), "/a.txt"))
+}
+
+func TestAppPasswords(t *testing.T) {
+	is := is.New(t)
+
+	alice := newUser("alice", "pa")
+	alice.AppPasswords = auth.NewAppPasswords(filepath.Join(t.TempDir(), auth.AppPasswordsFile))
+	phone, err := alice.AppPasswords.Add("phone")
+	is.NoErr(err)
+	c := &Config{User: &User{}, Auth: true, Users: map[string]*User{"alice": alice}}
+
+	is.Equal(do(c, "PUT", "/a.txt", "alice", phone, "x").Code, http.StatusCreated)
+	is.Equal(do(c, "GET", "/a.txt", "alice", "pa", "").Code, http.StatusOK)
+
+	is.NoErr(alice.AppPasswords.Revoke("phone"))
+	is.Equal(do(c, "GET", "/a.txt", "alice", phone, "").Code, http.StatusUnauthorized)
+	is.Equal(do(c, "GET", "/a.txt", "alice", "pa", "").Code, http.StatusOK)
+}
+
+func TestLockout(t *testing.T) {
+	is := is.New(t)
+
+	l := auth.NewLimiter()
+	l.Free = 1
+	a := auth.NewAudit(filepath.Join(t.TempDir(), auth.AuditFile))
+	c := &Config{
+		User:    &User{},
+		Auth:    true,
+		Users:   map[string]*User{"alice": newUser("alice", "pa")},
+		Limiter: l,
+		Audit:   a,
+	}
+
+	is.Equal(do(c, "GET", "/", "alice", "pa", "").Code, http.StatusMultiStatus)
+	is.Equal(do(c, "GET", "/", "alice", "nope", "").Code, http.StatusUnauthorized)
+	is.Equal(do(c, "GET", "/", "alice", "nope", "").Code, http.StatusUnauthorized)
+
+	// Even the right password waits now
+	w := do(c, "GET", "/", "alice", "pa", "")
+	is.Equal(w.Code, http.StatusTooManyRequests)
+	is.True(w.Header().Get("Retry-After") != "")
+
+	// The repeated failure is only recorded once
+	events, err := a.Events(10)
+	is.NoErr(err)
+	is.Equal(len(events), 3)
+	is.Equal(events[0].Kind, auth.EventLocked)
+	is.Equal(events[1].Kind, auth.EventFailure)
+	is.Equal(events[2].Kind, auth.EventLogin)
+}
+
+func TestSharedSecret(t *testing.T) {
+	is := is.New(t)
+
+	c := &Config{User: newUser("", "secret"), Auth: true}
+	is.Equal(do(c, "PUT", "/a.txt", "anyone", "secret", "x").Code, http.StatusCreated)
+	is.Equal(do(c, "GET", "/a.txt", "someone", "secret", "").Code, http.StatusOK)
+	is.Equal(do(c, "GET", "/a.txt", "anyone", "nope", "").Code, http.StatusUnauthorized)
+
+	// Tokens and proxies naming someone don't get the shared user
+	c.Tokens = auth.NewTokens(filepath.Join(t.TempDir(), auth.TokensFile))
+	shared, err := c.Tokens.Add("", "shared", []string{auth.ScopeRead})
+	is.NoErr(err)
+	bob, err := c.Tokens.Add("bob", "bob", []string{auth.ScopeRead})
+	is.NoErr(err)
+	for token, code := range map[string]int{shared: http.StatusOK, bob: http.StatusUnauthorized} {
+		r := httptest.NewRequest("GET", "/a.txt", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		c.ServeHTTP(w, r)
+		is.Equal(w.Code, code)
+	}
+	p, err := proxy.New([]string{"192.0.2.1"}, "X-Forwarded-User")
+	is.NoErr(err)
+	r := httptest.NewRequest("GET", "/a.txt", nil)
+	r.Header.Set("X-Forwarded-User", "bob")
+	w := httptest.NewRecorder()
+	p.Handler(c).ServeHTTP(w, r)
+	is.Equal(w.Code, http.StatusUnauthorized)
+
+	// Logging in on the web with any name keeps working
+	c.Sessions = auth.NewSessions([]byte("0123456789abcdef0123456789abcdef"))
+	ui := c.UI(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	form := url.Values{"username": {"anyone"}, "password": {"secret"}, "next": {"/info"}}
+	r = httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	ui.ServeHTTP(w, r)
+	is.Equal(w.Code, http.StatusSeeOther)
+	r = httptest.NewRequest("GET", "/info", nil)
+	r.AddCookie(w.Result().Cookies()[0])
+	w = httptest.NewRecorder()
+	ui.ServeHTTP(w, r)
+	is.Equal(w.Code, http.StatusOK)
+}
+
+func TestRules(t *testing.T) {
+	is := is.New(t)
+
+	u := newUser("alice", "pa")
+	u.Modify = false
+	u.Rules = []*Rule{
+		{Path: "/rw/", Allow: true, Modify: true},
+		{Regex: true, Regexp: regexp.MustCompile(`\.secret$`)},
+	}
+	c := &Config{User: &User{}, Auth: true, Users: map[string]*User{"alice": u}}
+
+	is.Equal(do(c, "MKCOL", "/rw/", "alice", "pa", "").Code, http.StatusCreated)
+	is.Equal(do(c, "PUT", "/rw/a.txt", "alice", "pa", "x").Code, http.StatusCreated)
+	is.Equal(do(c, "PUT", "/a.txt", "alice", "pa", "x").Code, http.StatusForbidden)
+	is.Equal(do(c, "GET", "/rw/a.txt", "alice", "pa", "").Code, http.StatusOK)
+	is.Equal(do(c, "GET", "/rw/a.secret", "alice", "pa", "").Code, http.StatusForbidden)
+}
+
+func TestCors(t *testing.T) {
+	is := is.New(t)
+
+	c := &Config{User: newUser("", "secret"), Auth: true, Cors: NewCors("https://app.example")}
+
+	// Preflight goes through without credentials
+	r := httptest.NewRequest("OPTIONS", "/", nil)
+	r.Header.Set("Origin", "https://app.example")
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, r)
+	is.Equal(w.Code, http.StatusOK)
+	is.Equal(w.Header().Get("Access-Control-Allow-Origin"), "https://app.example")
+	is.Equal(w.Header().Get("Access-Control-Allow-Credentials"), "true")
+	is.True(strings.Contains(w.Header().Get("Access-Control-Allow-Methods"), "PROPFIND"))
+
+	// Other origins get no CORS headers
+	r = httptest.NewRequest("OPTIONS", "/", nil)
+	r.Header.Set("Origin", "https://evil.example")
+	w = httptest.NewRecorder()
+	c.ServeHTTP(w, r)
+	is.Equal(w.Header().Get("Access-Control-Allow-Origin"), "")
+	r = httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Origin", "https://evil.example")
+	w = httptest.NewRecorder()
+	c.ServeHTTP(w, r)
+	is.Equal(w.Code, http.StatusUnauthorized)
+}
+
+func TestHandler(t *testing.T) {
+	is := is.New(t)
+
+	alice := newUser("alice", "pa")
+	c := &Config{User: &User{}, Auth: true, Users: map[string]*User{"alice": alice}}
+
+	var got *User
+	h := c.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = UserFrom(r.Context())
+	}))
+	is.Equal(do(h, "GET", "/info", "alice", "nope", "").Code, http.StatusUnauthorized)
+	is.Equal(got, nil)
+	is.Equal(do(h, "GET", "/info", "alice", "pa", "").Code, http.StatusOK)
+	is.Equal(got, alice)
+}
+
+func TestRoles(t *testing.T) {
+	is := is.New(t)
+
+	tablet := NewUser("tablet", "pt", RoleRead)
+	r, err := ParseRule("/kid.xbel=write")
+	is.NoErr(err)
+	tablet.Rules = append(tablet.Rules, r)
+	r, err = ParseRule("~^/history/=none")
+	is.NoErr(err)
+	tablet.Rules = append(tablet.Rules, r)
+	tablet.Handler = newUser("", "").Handler
+
+	c := &Config{User: &User{}, Auth: true, Users: map[string]*User{"tablet": tablet}}
+	is.Equal(do(c, "PUT", "/bookmarks.xbel", "tablet", "pt", "x").Code, http.StatusForbidden)
+	is.Equal(do(c, "PUT", "/kid.xbel", "tablet", "pt", "x").Code, http.StatusCreated)
+	is.Equal(do(c, "GET", "/kid.xbel", "tablet", "pt", "").Code, http.StatusOK)
+	is.Equal(do(c, "PROPFIND", "/history/", "tablet", "pt", "").Code, http.StatusForbidden)
+
+	is.True(!tablet.Is(RoleWrite))
+	is.True(NewUser("root", "", RoleAdmin).Is(RoleWrite))
+
+	_, err = ParseRule("/kid.xbel")
+	is.True(err != nil)
+	_, err = ParseRule("/kid.xbel=rw")
+	is.True(err != nil)
+	_, err = ParseRole("owner")
+	is.True(err != nil)
+}
+
+func TestUI(t *testing.T) {
+	is := is.New(t)
+
+	c := &Config{
+		User:     &User{},
+		Auth:     true,
+		Users:    map[string]*User{"alice": newUser("alice", "pa")},
+		Sessions: auth.NewSessions([]byte("0123456789abcdef0123456789abcdef")),
+	}
+	h := c.UI(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(UserFrom(r.Context()).Username + " " + CSRFToken(r.Context())))
+	}))
+
+	// Browsers are sent to the login form
+	w := do(h, "GET", "/info?c=work", "", "", "")
+	is.Equal(w.Code, http.StatusSeeOther)
+	is.Equal(w.Header().Get("Location"), "/login?next=%2Finfo%3Fc%3Dwork")
+
+	login := func(password, next string) *httptest.ResponseRecorder {
+		form := url.Values{"username": {"alice"}, "password": {password}, "next": {next}}
+		r := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	is.Equal(login("nope", "/info").Code, http.StatusUnauthorized)
+	w = login("pa", "//evil.example.com")
+	is.Equal(w.Code, http.StatusSeeOther)
+	is.Equal(w.Header().Get("Location"), "/info")
+	cookie := w.Result().Cookies()[0]
+
+	withCookie := func(method, path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	w = withCookie("GET", "/info", "")
+	is.Equal(w.Code, http.StatusOK)
+	parts := strings.Fields(w.Body.String())
+	is.Equal(parts[0], "alice")
+	token := parts[1]
+
+	// State changes need the token
+	is.Equal(withCookie("POST", "/info/restore", "").Code, http.StatusForbidden)
+	is.Equal(withCookie("POST", "/info/restore", "csrf=nope").Code, http.StatusForbidden)
+	is.Equal(withCookie("POST", "/info/restore", "csrf="+token).Code, http.StatusOK)
+
+	// Basic auth still works, with its own token
+	w = do(h, "GET", "/info", "alice", "pa", "")
+	is.Equal(w.Code, http.StatusOK)
+	is.True(strings.Fields(w.Body.String())[1] != token)
+	is.Equal(do(h, "POST", "/info/restore", "alice", "pa", "").Code, http.StatusForbidden)
+
+	// Logging out ends the session
+	is.Equal(withCookie("POST", "/logout", "").Code, http.StatusForbidden)
+	is.Equal(withCookie("POST", "/logout", "csrf="+token).Code, http.StatusSeeOther)
+	is.Equal(withCookie("GET", "/info", "").Code, http.StatusSeeOther)
+}
+
+func TestTokens(t *testing.T) {
+	is := is.New(t)
+
+	tokens := auth.NewTokens(filepath.Join(t.TempDir(), auth.TokensFile))
+	c := &Config{
+		User:     &User{},
+		Auth:     true,
+		Users:    map[string]*User{"alice": newUser("alice", "pa")},
+		Tokens:   tokens,
+		Sessions: auth.NewSessions([]byte("0123456789abcdef0123456789abcdef")),
+	}
+	read, err := tokens.Add("alice", "read", []string{auth.ScopeRead})
+	is.NoErr(err)
+	restore, err := tokens.Add("alice", "restore", []string{auth.ScopeRestore})
+	is.NoErr(err)
+	write, err := tokens.Add("alice", "write", []string{auth.ScopeWrite})
+	is.NoErr(err)
+
+	withToken := func(h http.Handler, method, path, token string) int {
+		r := httptest.NewRequest(method, path, strings.NewReader("x"))
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// WebDAV
+	is.Equal(withToken(c, "PUT", "/a.txt", "xbt_nope"), http.StatusUnauthorized)
+	is.Equal(withToken(c, "PUT", "/a.txt", read), http.StatusForbidden)
+	is.Equal(withToken(c, "PUT", "/a.txt", restore), http.StatusForbidden)
+	is.Equal(withToken(c, "PUT", "/a.txt", write), http.StatusCreated)
+	is.Equal(withToken(c, "GET", "/a.txt", read), http.StatusOK)
+
+	// The web UI, without CSRF tokens
+	ui := c.UI(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	is.Equal(withToken(ui, "GET", "/info", read), http.StatusOK)
+	is.Equal(withToken(ui, "POST", "/info/restore", read), http.StatusForbidden)
+	is.Equal(withToken(ui, "POST", "/info/restore", restore), http.StatusOK)
+
+	// Tokens can't do more than their user
+	u := NewUser("tablet", "", RoleRead).WithScopes([]string{auth.ScopeAdmin})
+	is.True(!u.Is(RoleWrite))
+	u = NewUser("root", "", RoleAdmin).WithScopes([]string{auth.ScopeWrite})
+	is.True(u.Is(RoleWrite))
+	is.True(!u.Is(RoleAdmin))
+}
+
+func TestProxy(t *testing.T) {
+	is := is.New(t)
+
+	c := &Config{
+		User:  &User{},
+		Auth:  true,
+		Users: map[string]*User{"alice": newUser("alice", "pa")},
+	}
+	p, err := proxy.New([]string{"192.0.2.1"}, "X-Forwarded-User")
+	is.NoErr(err)
+	h := p.Handler(c)
+
+	do := func(method, path, user string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		r.Header.Set("X-Forwarded-User", user)
+		r.Header.Set("X-Forwarded-Prefix", "/dav")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	is.Equal(do("PUT", "/a.txt", "alice").Code, http.StatusCreated)
+	is.Equal(do("GET", "/a.txt", "alice").Code, http.StatusOK)
+	is.Equal(do("GET", "/a.txt", "bob").Code, http.StatusUnauthorized)
+
+	// Listings include the prefix
+	w := do("PROPFIND", "/", "alice")
+	is.Equal(w.Code, http.StatusMultiStatus)
+	is.True(strings.Contains(w.Body.String(), "<D:href>/dav/a.txt</D:href>"))
+
+	// Only the proxy is trusted
+	r := httptest.NewRequest("GET", "/a.txt", nil)
+	r.RemoteAddr = "198.51.100.1:1234"
+	r.Header.Set("X-Forwarded-User", "alice")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	is.Equal(w.Code, http.StatusUnauthorized)
+}
@@ -5,6 +5,8 @@ import (
 	"errors"
 	"io"
 	"sort"
+
+	"github.com/dav-m85/xbellum/urlnorm"
 )
 
 const SUPPORTED_VERSION = "1.0"
@@ -28,8 +30,12 @@ type Folder struct {
 
 type Bookmark struct {
 	Title string `xml:"title"`
+	Desc  string `xml:"desc,omitempty"`
 	ID    int    `xml:"id,attr"`
 	Href  string `xml:"href,attr"`
+	// Added is when the bookmark was created, like 2006-01-02T15:04:05Z,
+	// when the client tells.
+	Added string `xml:"added,attr,omitempty"`
 }
 
 func MustParse(buf []byte) *XBEL {
@@ -74,17 +80,17 @@ func Bookmarks(x *XBEL) (res []*Bookmark) {
 }
 
 // Diff does a dual exclusion, returning only bookmarks that are unique to
-// a, or b.
-func Diff(a, b []*Bookmark) (onlyA, onlyB []*Bookmark) {
+// a, or b. Links are compared by their key with n.
+func Diff(a, b []*Bookmark, n *urlnorm.Normalizer) (onlyA, onlyB []*Bookmark) {
 	am := make(map[string]*Bookmark)
 	bm := make(map[string]*Bookmark)
 	for _, x := range a {
 		nx := *x
-		am[x.Href] = &nx // am contains all a
+		am[n.Key(x.Href)] = &nx // am contains all a
 	}
 	for _, x := range b {
 		nx := *x
-		bm[x.Href] = &nx // bm contains all b
+		bm[n.Key(x.Href)] = &nx // bm contains all b
 	}
 	for k, x := range bm {
 		if _, ok := am[k]; !ok {
@@ -107,6 +113,24 @@ func (a sortByHref) Len() int           { return len(a) }
 func (a sortByHref) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a sortByHref) Less(i, j int) bool { return a[i].Href < a[j].Href }
 
+// Each calls f with every bookmark in the order Walk visits them, along with
+// the titles of folders leading to it.
+func Each(x *XBEL, f func(path []string, b *Bookmark)) {
+	for i := range x.Folders {
+		eachFolder(&x.Folders[i], nil, f)
+	}
+}
+
+func eachFolder(folder *Folder, path []string, f func(path []string, b *Bookmark)) {
+	path = append(path[:len(path):len(path)], folder.Title)
+	for i := range folder.Bookmarks {
+		f(path, &folder.Bookmarks[i])
+	}
+	for i := range folder.Folders {
+		eachFolder(&folder.Folders[i], path, f)
+	}
+}
+
 // Walk modifies passed XBEL file with a Filter
 func Walk(x *XBEL, filter Filter) *XBEL {
 	var nf []Folder
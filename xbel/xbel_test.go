@@ -3,6 +3,7 @@ package xbel
 import (
 	"testing"
 
+	"github.com/dav-m85/xbellum/urlnorm"
 	"github.com/matryer/is"
 )
 
@@ -15,9 +16,17 @@ func TestDiff(t *testing.T) {
 	b := []*Bookmark{
 		{Href: "foo"}, {Href: "baz"}, {Href: "plop"},
 	}
-	onlyA, onlyB := Diff(a, b)
+	onlyA, onlyB := Diff(a, b, nil)
 	is.True(len(onlyA) == 1)
 	is.True(len(onlyB) == 1)
 	is.True(onlyA[0].Href == "bar")
 	is.True(onlyB[0].Href == "baz")
+
+	// Variants of a link are the same bookmark, unless told otherwise
+	variantA := []*Bookmark{{Href: "http://www.example.com/a/?utm_source=x"}}
+	variantB := []*Bookmark{{Href: "https://example.com/a"}}
+	onlyA, onlyB = Diff(variantA, variantB, urlnorm.New())
+	is.Equal(len(onlyA)+len(onlyB), 0)
+	onlyA, onlyB = Diff(variantA, variantB, nil)
+	is.Equal(len(onlyA)+len(onlyB), 2)
 }